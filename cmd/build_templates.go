@@ -3,10 +3,11 @@ package cmd
 import (
 	"fmt"
 	"os"
-	"os/exec"
+	"sort"
 
 	"github.com/evankanderson/knuts/pkg"
 	"github.com/evankanderson/knuts/pkg/builds"
+	"github.com/evankanderson/knuts/pkg/lock"
 	"github.com/spf13/cobra"
 )
 
@@ -16,6 +17,14 @@ func init() {
 	buildTemplateCmd.PersistentFlags().Var(&gcpProject, "gcp_project", gcpProject.Description)
 	buildTemplateCmd.PersistentFlags().Var(&dockerUser, "docker_username", dockerUser.Description)
 	buildTemplateCmd.PersistentFlags().Var(&registries, "registry", registries.Description)
+	buildTemplateCmd.Flags().StringVar(&bundlePath, "bundle", "", "Install from a bundle created by `knuts bundle create` instead of downloading manifests.")
+	buildTemplateCmd.Flags().StringVar(&pkg.Default.ImageRegistry, "image-registry", "", "Pull all images from this registry mirror instead, e.g. registry.internal/knative. See `knuts images relocate`.")
+	buildTemplateCmd.Flags().StringVar(&lockPath, "lockfile", lock.DefaultPath, "Lockfile recording exactly which build templates were installed.")
+	buildTemplateCmd.Flags().BoolVar(&skipChecks, "skip-checks", false, "Install without running the preflight checks from `knuts doctor`.")
+	buildTemplateCmd.Flags().StringSliceVar(&buildNamespaceNames, "namespace", nil, "Set up builds in these namespaces, rather than the current one. May be repeated.")
+	buildTemplateCmd.Flags().BoolVar(&allNamespaces, "all-namespaces", false, "Set up builds in every namespace matching --selector.")
+	buildTemplateCmd.Flags().StringVar(&namespaceSelector, "selector", "", "Label selector for the namespaces to set up with --all-namespaces, e.g. team=a.")
+	buildTemplateCmd.Flags().BoolVar(&locked, "locked", false, "Install exactly the build templates recorded in --lockfile.")
 }
 
 var (
@@ -36,6 +45,10 @@ var (
 			},
 		},
 	}
+	buildNamespaceNames []string
+	allNamespaces       bool
+	namespaceSelector   string
+
 	gcpProject = pkg.Prompt{
 		Description: "GCP Project to push images to",
 	}
@@ -49,78 +62,208 @@ var buildTemplateCmd = &cobra.Command{
 	Aliases: []string{"builds", "bt"},
 	Short:   "Menu-guided install of build templates.",
 	Run: func(cmd *cobra.Command, args []string) {
-		if err := pkg.Installed("kubectl"); err != nil {
-			fmt.Print(err)
+		if err := pkg.Default.Access(); err != nil {
+			fmt.Println(err)
 			os.Exit(2)
 		}
+		if err := useBundle(); err != nil {
+			fmt.Println(err)
+			os.Exit(2)
+		}
+		defer closeBundle()
 
-		templates := builds.Builds.Get().([]pkg.Option)
-
-		for _, t := range templates {
-			b := builds.BuildTemplate(t)
-			err := b.Install()
+		namespaces, err := buildNamespaces()
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(2)
+		}
+		templates, commits, err := buildTemplates(cmd)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(2)
+		}
+		if err := preflight(templateChecks(templates)); err != nil {
+			fmt.Println(err)
+			os.Exit(2)
+		}
 
-			if err != nil {
-				if ee, ok := err.(*exec.ExitError); ok {
-					fmt.Printf("Failed to install %s: %v:\n%s\n", b.Description, ee, ee.Stderr)
-				} else {
-					fmt.Printf("Failed to install %s: %v\n", b.Description, err)
-				}
-				// For now, continue to the next install
+		if !pkg.Default.DryRun {
+			// Ask once up front, before registrySecrets creates keys and
+			// before changing any namespace.
+			if err := pkg.Confirm(os.Stdout, pkg.Default); err != nil {
+				fmt.Println(err)
+				os.Exit(2)
 			}
 		}
 
+		// Registry secrets are set up once, so that every namespace shares
+		// the same GCR key.
+		secrets := []builds.ImageSecret{}
 		if len(templates) > 0 {
-			// Create service account with access to created secrets
-			kubeSa := `
+			secrets = registrySecrets()
+		}
+		succeeded := map[string]bool{}
+		for _, ns := range namespaces {
+			cluster := pkg.Default
+			if ns != "" {
+				cluster = pkg.Default.InNamespace(ns)
+				fmt.Printf("Namespace %s:\n", ns)
+			}
+			for _, t := range setupBuilds(cluster, templates, secrets) {
+				succeeded[t.Name] = true
+			}
+		}
+
+		installed := []builds.Template{}
+		for _, t := range templates {
+			if succeeded[t.Name] {
+				installed = append(installed, t)
+			}
+		}
+		if !locked && !pkg.Default.DryRun && len(installed) > 0 {
+			if err := lockTemplates(installed, commits); err != nil {
+				fmt.Println(err)
+			} else {
+				fmt.Printf("Recorded installed build templates in %s\n", lockPath)
+			}
+		}
+	},
+}
+
+// buildNamespaces returns the namespaces to set up builds in: those given by
+// --namespace, or those matching --selector with --all-namespaces. Otherwise
+// it returns "", for the namespace of the kubeconfig context.
+func buildNamespaces() ([]string, error) {
+	if allNamespaces {
+		if len(buildNamespaceNames) > 0 {
+			return nil, fmt.Errorf("--namespace cannot be combined with --all-namespaces")
+		}
+		names, err := pkg.Default.Namespaces(namespaceSelector)
+		if err != nil {
+			return nil, err
+		}
+		if len(names) == 0 {
+			return nil, fmt.Errorf("No namespaces match --selector %q", namespaceSelector)
+		}
+		return names, nil
+	}
+	if namespaceSelector != "" {
+		return nil, fmt.Errorf("--selector needs --all-namespaces")
+	}
+	if len(buildNamespaceNames) == 0 {
+		return []string{""}, nil
+	}
+	for _, n := range buildNamespaceNames {
+		ok, err := pkg.Default.ObjectExists("namespace", n)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return nil, fmt.Errorf("Namespace %q not found", n)
+		}
+	}
+	return buildNamespaceNames, nil
+}
+
+// registrySecrets sets up the registries selected by --registry.
+func registrySecrets() []builds.ImageSecret {
+	secrets := []builds.ImageSecret{}
+	for _, f := range registries.Get().([]pkg.Option) {
+		setup := f.Data.(func() (builds.ImageSecret, error))
+		s, err := setup()
+		if err != nil {
+			fmt.Printf("Failed to set up %q: %v\n", f.Description, err)
+			continue
+		}
+		secrets = append(secrets, s)
+	}
+	return secrets
+}
+
+// setupBuilds installs templates, the registry secrets and the builder
+// ServiceAccount in cluster's namespace, and returns the templates which
+// were installed.
+func setupBuilds(cluster *pkg.Cluster, templates []builds.Template, secrets []builds.ImageSecret) []builds.Template {
+	out := cluster.Output()
+	installed := []builds.Template{}
+	for _, t := range templates {
+		b := builds.BuildTemplate{Description: t.Name, Data: t}
+		err := b.Install(cluster)
+
+		if err != nil {
+			fmt.Fprintf(out, "Failed to install %s: %v\n", b.Description, err)
+			// For now, continue to the next install
+			continue
+		}
+		installed = append(installed, t)
+	}
+	if len(templates) == 0 {
+		return installed
+	}
+
+	// Create service account with access to created secrets
+	kubeSa := fmt.Sprintf(`
 apiVersion: v1
 kind: ServiceAccount
 metadata:
-  name: builder
+  name: %s
 secrets:
-`
-			// Set up registry secrets
-			secrets := []builds.ImageSecret{}
-			for _, f := range registries.Get().([]pkg.Option) {
-				setup := f.Data.(func() (builds.ImageSecret, error))
-				s, err := setup()
-				if err != nil {
-					fmt.Printf("Failed to set up %q: %v", f.Description, err)
-				}
-				secrets = append(secrets, s)
-			}
+`, builds.ServiceAccount)
+	for _, s := range secrets {
+		yaml, err := builds.ProduceK8sSecret(s)
+		if err != nil {
+			fmt.Fprintf(out, "Skipping secret %q: %v\n", s.Provider, err)
+			continue
+		}
+		if cluster.DryRun {
+			fmt.Fprintf(out, "%s\n", yaml)
+			continue
+		}
+		err = cluster.KubectlInline(yaml)
+		if err != nil {
+			fmt.Fprintf(out, "Failed to apply secret for %s: %v\n", s.Provider, err)
+			// For now, continue to the next secret
+			continue
+		}
+		kubeSa = fmt.Sprintf("%s  - name: %s\n", kubeSa, s.Provider)
+	}
 
-			for _, s := range secrets {
-				out, err := builds.ProduceK8sSecret(s)
-				if err != nil {
-					fmt.Printf("Skipping secret %q: %v", s.Provider, err)
-					continue
-				}
-				if pkg.DryRun {
-					fmt.Printf("%s\n", out)
-					continue
-				}
-				err = pkg.KubectlInline(out, os.Stdout)
-				if err != nil {
-					if ee, ok := err.(*exec.ExitError); ok {
-						fmt.Printf("Failed to apply secret for %s: %v:\n%s\n", s.Provider, ee, ee.Stderr)
-					} else {
-						fmt.Printf("Failed to apply secret for %s: %v\n", s.Provider, err)
-					}
-					// For now, continue to the next secret
-					continue
-				}
-				kubeSa = fmt.Sprintf("%s  - name: %s\n", kubeSa, s.Provider)
-			}
+	err := cluster.KubectlInline([]byte(kubeSa))
+	if err != nil {
+		fmt.Fprintf(out, "Failed to create ServiceAccount: %v\n", err)
+	}
+	return installed
+}
 
-			err := pkg.KubectlInline([]byte(kubeSa), os.Stdout)
+// buildTemplates returns the build templates to install, either from the
+// lockfile if --locked is set or as selected by --templates. Selected
+// templates are pinned to the current commit of their branch where
+// possible (except in dry runs), so that the lockfile records exactly what
+// was installed; the commits are returned keyed by template name.
+func buildTemplates(cmd *cobra.Command) ([]builds.Template, map[string]string, error) {
+	if locked {
+		if err := checkLocked(cmd, "templates", "image-registry"); err != nil {
+			return nil, nil, err
+		}
+		templates, err := lockedTemplates()
+		return templates, nil, err
+	}
+	templates := []builds.Template{}
+	commits := map[string]string{}
+	for _, o := range builds.Builds.Get().([]pkg.Option) {
+		t := o.Data.(builds.Template)
+		// Bundles hold manifests by their original URL, and dry runs don't
+		// write the lockfile, so neither needs the commit.
+		if pkg.Bundle == nil && !pkg.Default.DryRun {
+			pinned, commit, err := t.Pin()
 			if err != nil {
-				if ee, ok := err.(*exec.ExitError); ok {
-					fmt.Printf("Failed to create ServiceAccount: %v:\n%s\n", ee, ee.Stderr)
-				} else {
-					fmt.Printf("Failed to create ServiceAccount: %v\n", err)
-				}
+				fmt.Printf("Unable to pin %s to a commit, locking it by digest only: %v\n", t.Name, err)
+			} else {
+				t, commits[t.Name] = pinned, commit
 			}
 		}
-	},
+		templates = append(templates, t)
+	}
+	sort.Slice(templates, func(i, j int) bool { return templates[i].Name < templates[j].Name })
+	return templates, commits, nil
 }
@@ -0,0 +1,87 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/evankanderson/knuts/pkg"
+	"github.com/evankanderson/knuts/pkg/builds"
+	"github.com/evankanderson/knuts/pkg/bundle"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	rootCmd.AddCommand(bundleCmd)
+	bundleCmd.AddCommand(bundleCreateCmd)
+	bundleCreateCmd.Flags().Var(&componentsFlag, "components", componentsFlag.Description)
+	bundleCreateCmd.Flags().Var(&versionFlag, "version", versionFlag.Description)
+	bundleCreateCmd.Flags().StringToStringVar(&prefer, "prefer", nil, "Provider to use for a virtual dependency, e.g. ingress=istio-lean. May be repeated.")
+	bundleCreateCmd.Flags().StringVar(&catalogPath, "catalog", "", "YAML or JSON component catalog to use instead of the built-in one.")
+	bundleCreateCmd.Flags().Var(&builds.Builds, "templates", "Which build templates to include in the bundle")
+}
+
+// bundlePath is the --bundle flag shared by commands which apply manifests.
+var bundlePath string
+
+// useBundle makes pkg.Kubectl read manifests from the bundle named by
+// --bundle, if set.
+func useBundle() error {
+	if bundlePath == "" {
+		return nil
+	}
+	b, err := bundle.Open(bundlePath)
+	if err != nil {
+		return err
+	}
+	pkg.Bundle = b
+	return nil
+}
+
+// closeBundle cleans up after useBundle.
+func closeBundle() {
+	if pkg.Bundle != nil {
+		pkg.Bundle.Close()
+	}
+}
+
+var bundleCmd = &cobra.Command{
+	Use:   "bundle",
+	Short: "Manage bundles of manifests for installing without network access.",
+}
+
+var bundleCreateCmd = &cobra.Command{
+	Use:   "create PATH",
+	Short: "Download manifests for the selected components and build templates into a directory or .tar.gz.",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		plan, err := resolvePlan()
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(2)
+		}
+		manifests := map[string][]byte{}
+		fetch := func(url string, digest string) {
+			fmt.Printf("Fetching %s\n", url)
+			contents, err := pkg.Fetch(url, digest)
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+			manifests[url] = contents
+		}
+		for _, s := range plan.Steps {
+			fetch(s.Manifest, s.Component.Digest)
+		}
+		if cmd.Flags().Changed("templates") {
+			for _, o := range builds.Builds.Get().([]pkg.Option) {
+				t := o.Data.(builds.Template)
+				fetch(t.URL, t.Digest)
+			}
+		}
+		if err := bundle.Create(args[0], manifests); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		fmt.Printf("Wrote %d manifests to %s\n", len(manifests), args[0])
+	},
+}
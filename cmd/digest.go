@@ -0,0 +1,31 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/evankanderson/knuts/pkg"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	rootCmd.AddCommand(digestCmd)
+}
+
+var digestCmd = &cobra.Command{
+	Use:   "digest URL...",
+	Short: "Print the sha256 digests of manifests, for pinning in a catalog.",
+	Args:  cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		// Computing the digest is how an unverified manifest gets pinned.
+		pkg.AllowUnverified = true
+		for _, url := range args {
+			contents, err := pkg.Fetch(url, "")
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+			fmt.Printf("%s  %s\n", pkg.Digest(contents), url)
+		}
+	},
+}
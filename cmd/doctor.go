@@ -0,0 +1,127 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/evankanderson/knuts/pkg"
+	"github.com/evankanderson/knuts/pkg/builds"
+	"github.com/evankanderson/knuts/pkg/doctor"
+	"github.com/evankanderson/knuts/pkg/install"
+	"github.com/evankanderson/knuts/pkg/lock"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	rootCmd.AddCommand(doctorCmd)
+	doctorCmd.Flags().Var(&componentsFlag, "components", "Which components to check for")
+	doctorCmd.Flags().Var(&versionFlag, "version", versionFlag.Description)
+	doctorCmd.Flags().StringToStringVar(&prefer, "prefer", nil, "Provider to use for a virtual dependency, e.g. ingress=istio-lean. May be repeated.")
+	doctorCmd.Flags().StringVar(&catalogPath, "catalog", "", "YAML or JSON component catalog to use instead of the built-in one.")
+	doctorCmd.Flags().StringVar(&bundlePath, "bundle", "", "Check a bundle created by `knuts bundle create` instead of downloading manifests.")
+	doctorCmd.Flags().StringVar(&lockPath, "lockfile", lock.DefaultPath, "Lockfile to read with --locked.")
+	doctorCmd.Flags().BoolVar(&locked, "locked", false, "Check for the components recorded in --lockfile, rather than resolving them from the catalog.")
+	doctorCmd.Flags().VarP(&doctorFormat, "output", "o", doctorFormat.Description())
+}
+
+var (
+	doctorFormat = pkg.Output{Formats: []string{"table", "json", "yaml"}}
+	// skipChecks disables the preflight checks run by install and builds.
+	skipChecks bool
+)
+
+var doctorCmd = &cobra.Command{
+	Use:   "doctor",
+	Short: "Check that the cluster is ready to install Knative components.",
+	Long: `Check that the cluster is ready to install Knative components.
+
+Checks the kubectl and cluster versions against the versions each component
+supports, that you have cluster-admin, that the nodes have enough CPU and
+memory for the components' Deployments, and that their manifests can be
+fetched. These checks also run before ` + "`knuts install`" + ` and ` + "`knuts builds`" + `.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		plan, _, err := installPlan(cmd)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(2)
+		}
+		if err := useBundle(); err != nil {
+			fmt.Println(err)
+			os.Exit(2)
+		}
+		defer closeBundle()
+		results := doctor.Run(installChecks(pkg.Default, plan))
+		if err := printChecks(os.Stdout, results); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		if doctor.Failed(results) {
+			os.Exit(1)
+		}
+	},
+}
+
+// installChecks returns the checks to run before installing plan to
+// cluster.
+func installChecks(cluster *pkg.Cluster, plan *install.Plan) doctor.Options {
+	o := doctor.Options{Cluster: cluster, ClusterAdmin: true, Resources: true}
+	for _, s := range plan.Steps {
+		o.Manifests = append(o.Manifests, doctor.Manifest{
+			Name:       s.Name,
+			URL:        s.Manifest,
+			Digest:     s.Component.Digest,
+			Kubernetes: s.Component.Kubernetes,
+		})
+	}
+	return o
+}
+
+// templateChecks returns the checks to run before installing templates.
+func templateChecks(templates []builds.Template) doctor.Options {
+	o := doctor.Options{}
+	for _, t := range templates {
+		o.Manifests = append(o.Manifests, doctor.Manifest{Name: t.Name, URL: t.URL, Digest: t.Digest})
+	}
+	return o
+}
+
+// preflight runs checks before changing a cluster (pkg.Default, unless
+// o.Cluster is set), unless --skip-checks is set. Failed checks are an
+// error, except in a dry run.
+func preflight(o doctor.Options) error {
+	if skipChecks {
+		return nil
+	}
+	cluster := o.Cluster
+	if cluster == nil {
+		cluster = pkg.Default
+	}
+	results := doctor.Run(o)
+	if err := printChecks(cluster.Output(), results); err != nil {
+		return err
+	}
+	fmt.Fprintln(cluster.Output())
+	if !doctor.Failed(results) {
+		return nil
+	}
+	if cluster.DryRun {
+		fmt.Fprintln(cluster.Output(), "Preflight checks failed; continuing because this is a dry run.")
+		return nil
+	}
+	return fmt.Errorf("Preflight checks failed; fix the problems above, or use --skip-checks to install anyway")
+}
+
+func printChecks(out io.Writer, results []doctor.Result) error {
+	if f := doctorFormat.String(); f != "table" {
+		return pkg.PrintStructured(out, f, results)
+	}
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "CHECK\tSTATUS\tDETAILS")
+	for _, r := range results {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", r.Check, strings.ToUpper(string(r.Status)), r.Message)
+	}
+	return w.Flush()
+}
@@ -0,0 +1,179 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/evankanderson/knuts/pkg"
+	"github.com/evankanderson/knuts/pkg/domain"
+	"github.com/evankanderson/knuts/pkg/gcp"
+	"github.com/evankanderson/knuts/pkg/install"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	rootCmd.AddCommand(domainCmd)
+	domainCmd.AddCommand(domainSetCmd)
+	domainCmd.AddCommand(domainDNSCmd)
+	domainSetCmd.Flags().StringToStringVar(&domainSelector, "selector", nil, "Only use the domain for Services with these labels, e.g. app=prod.")
+	domainSetCmd.Flags().StringVar(&wildcardDNS, "wildcard-dns", "", "Use a wildcard DNS service such as nip.io with the ingress gateway's IP, e.g. 1.2.3.4.nip.io, instead of a DOMAIN.")
+	domainDNSCmd.Flags().Var(&gcpProject, "gcp_project", "GCP Project which owns the Cloud DNS zone")
+	domainDNSCmd.Flags().StringVar(&dnsZone, "zone", "", "Cloud DNS managed zone to create the record in.")
+	domainDNSCmd.Flags().StringVar(&dnsDomain, "domain", "", "Domain to create a wildcard record for (default: the configured default domain).")
+	domainDNSCmd.Flags().Int64Var(&dnsTTL, "ttl", 300, "TTL of the record, in seconds.")
+	domainDNSCmd.MarkFlagRequired("zone")
+}
+
+var (
+	domainSelector map[string]string
+	wildcardDNS    string
+	dnsZone        string
+	dnsDomain      string
+	dnsTTL         int64
+)
+
+var domainCmd = &cobra.Command{
+	Use:   "domain",
+	Short: "Configure the domain names used for Knative Services.",
+}
+
+var domainSetCmd = &cobra.Command{
+	Use:   "set [DOMAIN]",
+	Short: "Set the domain used for Knative Services, either by default or for those matching --selector.",
+	Args:  cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := pkg.Default.Access(); err != nil {
+			fmt.Println(err)
+			os.Exit(2)
+		}
+		if (len(args) == 1) == (wildcardDNS != "") {
+			fmt.Println("Specify exactly one of DOMAIN or --wildcard-dns")
+			os.Exit(2)
+		}
+		name := ""
+		if len(args) == 1 {
+			name = args[0]
+		} else {
+			ip, err := ingressAddress()
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+			if net.ParseIP(ip) == nil {
+				fmt.Printf("--wildcard-dns needs an IP address, but the ingress gateway's address is %s\n", ip)
+				os.Exit(1)
+			}
+			name = fmt.Sprintf("%s.%s", ip, wildcardDNS)
+		}
+
+		config, err := domain.Get()
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		patch, err := json.Marshal(map[string]interface{}{"data": config.Set(name, domainSelector)})
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		if err := pkg.Default.Patch("configmap", domain.Namespace, domain.ConfigMap, patch); err != nil {
+			fmt.Printf("Failed to update %s: %v\n", domain.ConfigMap, err)
+			os.Exit(1)
+		}
+
+		services, err := domain.Services()
+		if err != nil {
+			fmt.Printf("Unable to list Knative Services: %v\n", err)
+			return
+		}
+		if len(services) == 0 {
+			fmt.Printf("Knative Services will be available at NAME.NAMESPACE.%s\n", config.DomainFor(domainSelector))
+			return
+		}
+		sort.Slice(services, func(i, j int) bool {
+			if services[i].Namespace != services[j].Namespace {
+				return services[i].Namespace < services[j].Namespace
+			}
+			return services[i].Name < services[j].Name
+		})
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "NAMESPACE\tSERVICE\tHOST")
+		for _, s := range services {
+			fmt.Fprintf(w, "%s\t%s\t%s\n", s.Namespace, s.Name, domain.Host(s.Name, s.Namespace, config.DomainFor(s.Labels)))
+		}
+		w.Flush()
+	},
+}
+
+var domainDNSCmd = &cobra.Command{
+	Use:   "dns",
+	Short: "Point a wildcard record in Cloud DNS at the ingress gateway.",
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := pkg.Default.Access(); err != nil {
+			fmt.Println(err)
+			os.Exit(2)
+		}
+		name := dnsDomain
+		if name == "" {
+			config, err := domain.Get()
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+			if name = config.Default(); name == domain.DefaultDomain {
+				fmt.Println("No domain is configured; use `knuts domain set` or --domain")
+				os.Exit(2)
+			}
+		}
+		ip, err := ingressAddress()
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		if net.ParseIP(ip) == nil {
+			fmt.Printf("Cloud DNS A records need an IP address, but the ingress gateway's address is %s\n", ip)
+			os.Exit(1)
+		}
+
+		client, project, err := gcp.Client(context.Background())
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		if cmd.Flags().Changed("gcp_project") || project == "" {
+			project = gcpProject.Get().(string)
+		}
+		if err := gcp.EnsureServices(client, "projects/"+project, []string{"dns.googleapis.com"}); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		if err := domain.SetWildcardRecord(client, project, dnsZone, name, ip, dnsTTL); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	},
+}
+
+// ingressAddress returns the external address of the first ingress gateway
+// Service from the catalog which exists in the cluster.
+func ingressAddress() (string, error) {
+	gateways := install.Gateways()
+	names := []string{}
+	for _, gw := range gateways {
+		ok, err := pkg.Default.InNamespace(gw.Namespace).ObjectExists("service", gw.Name)
+		if err != nil {
+			return "", err
+		}
+		if ok {
+			return domain.IngressAddress(gw.Namespace, gw.Name)
+		}
+		names = append(names, gw.Namespace+"/"+gw.Name)
+	}
+	return "", fmt.Errorf("No ingress gateway found; looked for Services %s", strings.Join(names, ", "))
+}
@@ -0,0 +1,137 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"text/tabwriter"
+
+	"github.com/evankanderson/knuts/pkg"
+	"github.com/evankanderson/knuts/pkg/fleet"
+	"github.com/evankanderson/knuts/pkg/install"
+)
+
+var (
+	contexts    []string
+	fleetPath   string
+	concurrency int
+)
+
+// fleetClusters returns a Cluster for each context selected by --contexts
+// or --fleet, with the options of pkg.Default. Their output is written to
+// stdout (holding mu), prefixed by the context.
+func fleetClusters(mu *sync.Mutex) ([]*pkg.Cluster, error) {
+	if len(contexts) > 0 && fleetPath != "" {
+		return nil, fmt.Errorf("--contexts and --fleet cannot be combined")
+	}
+	if pkg.Default.Context != "" {
+		return nil, fmt.Errorf("--context selects a single cluster; it cannot be combined with --contexts or --fleet")
+	}
+	var f *fleet.Fleet
+	var err error
+	if fleetPath != "" {
+		f, err = fleet.Read(fleetPath)
+	} else {
+		f, err = fleet.Contexts(contexts)
+	}
+	if err != nil {
+		return nil, err
+	}
+	ret := []*pkg.Cluster{}
+	for _, m := range f.Clusters {
+		kubeconfig := m.Kubeconfig
+		if kubeconfig == "" {
+			kubeconfig = pkg.Default.Kubeconfig
+		}
+		ret = append(ret, &pkg.Cluster{
+			Kubeconfig:    kubeconfig,
+			Context:       m.Context,
+			DryRun:        pkg.Default.DryRun,
+			UseKubectl:    pkg.Default.UseKubectl,
+			ImageRegistry: pkg.Default.ImageRegistry,
+			Out:           fleet.NewWriter(os.Stdout, mu, fmt.Sprintf("[%s] ", m.Context)),
+		})
+	}
+	return ret, nil
+}
+
+// installFleet installs plan to each cluster selected by --contexts or
+// --fleet, up to --concurrency at once, and prints a summary.
+func installFleet(plan *install.Plan, release string) {
+	if staticIP != "" {
+		fmt.Println("--static-ip reserves an address for a single cluster; it cannot be combined with --contexts or --fleet")
+		os.Exit(2)
+	}
+	var mu sync.Mutex
+	clusters, err := fleetClusters(&mu)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(2)
+	}
+	servers := []string{}
+	for _, c := range clusters {
+		if err := c.Access(); err != nil {
+			fmt.Printf("%s: %v\n", c.Context, err)
+			os.Exit(2)
+		}
+		_, server, err := c.Server()
+		if err != nil {
+			fmt.Printf("%s: %v\n", c.Context, err)
+			os.Exit(2)
+		}
+		servers = append(servers, server)
+	}
+	if err := useBundle(); err != nil {
+		fmt.Println(err)
+		os.Exit(2)
+	}
+	defer closeBundle()
+	if !pkg.Default.DryRun {
+		// Ask once up front, rather than from each parallel install.
+		if err := pkg.Confirm(os.Stdout, clusters...); err != nil {
+			fmt.Println(err)
+			os.Exit(2)
+		}
+	}
+
+	errs := fleet.Run(len(clusters), concurrency, func(i int) error {
+		c := clusters[i]
+		defer c.Out.(*fleet.Writer).Flush()
+		if err := preflight(installChecks(c, plan)); err != nil {
+			return err
+		}
+		if err := useOverlay(c); err != nil {
+			return err
+		}
+		return installTo(c, plan, "")
+	})
+
+	fmt.Println()
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "CONTEXT\tSERVER\tRESULT")
+	failed := 0
+	for i, c := range clusters {
+		result := "installed"
+		if pkg.Default.DryRun {
+			result = "dry run"
+		}
+		if errs[i] != nil {
+			failed++
+			result = fmt.Sprintf("FAILED: %v", errs[i])
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", c.Context, servers[i], result)
+	}
+	w.Flush()
+
+	if !locked && !pkg.Default.DryRun && failed == 0 {
+		if err := lockComponents(release, plan); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		fmt.Printf("Recorded installed components in %s\n", lockPath)
+	}
+	if failed > 0 {
+		fmt.Printf("Failed to install to %d of %d clusters\n", failed, len(clusters))
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,225 @@
+package cmd
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/evankanderson/knuts/pkg"
+	"github.com/evankanderson/knuts/pkg/builds"
+	"github.com/evankanderson/knuts/pkg/images"
+	"github.com/evankanderson/knuts/pkg/lock"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	rootCmd.AddCommand(imagesCmd)
+	imagesCmd.AddCommand(imagesListCmd)
+	imagesCmd.AddCommand(imagesRelocateCmd)
+	imagesCmd.PersistentFlags().Var(&componentsFlag, "components", componentsFlag.Description)
+	imagesCmd.PersistentFlags().Var(&versionFlag, "version", versionFlag.Description)
+	imagesCmd.PersistentFlags().StringToStringVar(&prefer, "prefer", nil, "Provider to use for a virtual dependency, e.g. ingress=istio-lean. May be repeated.")
+	imagesCmd.PersistentFlags().StringVar(&catalogPath, "catalog", "", "YAML or JSON component catalog to use instead of the built-in one.")
+	imagesCmd.PersistentFlags().Var(&builds.Builds, "templates", "Which build templates to include")
+	imagesCmd.PersistentFlags().StringVar(&lockPath, "lockfile", lock.DefaultPath, "Lockfile to read with --locked.")
+	imagesCmd.PersistentFlags().BoolVar(&locked, "locked", false, "Use the components and build templates recorded in --lockfile.")
+	imagesCmd.PersistentFlags().VarP(&imagesFormat, "output", "o", imagesFormat.Description())
+	imagesRelocateCmd.Flags().StringVar(&relocateRegistry, "registry", "", "Registry to relocate images to, e.g. registry.internal/knative.")
+	imagesRelocateCmd.MarkFlagRequired("registry")
+}
+
+var (
+	imagesFormat     = pkg.Output{Formats: []string{"text", "json", "csv"}}
+	relocateRegistry string
+)
+
+// manifestSource is a manifest selected by the `knuts images` flags.
+type manifestSource struct {
+	Name   string
+	URL    string
+	Digest string
+}
+
+// selectedManifests returns the component and build template manifests
+// selected by flags. Components are selected (prompting if needed) unless
+// only --templates was given.
+func selectedManifests(cmd *cobra.Command) ([]manifestSource, error) {
+	ret := []manifestSource{}
+	if locked {
+		if err := checkLocked(cmd, "components", "version", "prefer", "catalog", "templates"); err != nil {
+			return nil, err
+		}
+		l, err := lock.Read(lockPath)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to read lockfile: %v", err)
+		}
+		for _, c := range l.Components {
+			ret = append(ret, manifestSource{Name: c.Name, URL: c.URL, Digest: c.SHA256})
+		}
+		for _, t := range l.Templates {
+			ret = append(ret, manifestSource{Name: t.Name, URL: t.URL, Digest: t.SHA256})
+		}
+		return ret, nil
+	}
+
+	if cmd.Flags().Changed("components") || !cmd.Flags().Changed("templates") {
+		plan, err := resolvePlan()
+		if err != nil {
+			return nil, err
+		}
+		for _, s := range plan.Steps {
+			ret = append(ret, manifestSource{Name: s.Name, URL: s.Manifest, Digest: s.Component.Digest})
+		}
+	}
+	if cmd.Flags().Changed("templates") {
+		templates := []manifestSource{}
+		for _, o := range builds.Builds.Get().([]pkg.Option) {
+			t := o.Data.(builds.Template)
+			templates = append(templates, manifestSource{Name: t.Name, URL: t.URL, Digest: t.Digest})
+		}
+		sort.Slice(templates, func(i, j int) bool { return templates[i].Name < templates[j].Name })
+		ret = append(ret, templates...)
+	}
+	return ret, nil
+}
+
+// printRows prints a table of rows in the text or csv format, or v in
+// another structured format.
+func printRows(format string, header []string, rows [][]string, v interface{}) error {
+	switch format {
+	case "text":
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, strings.ToUpper(strings.Join(header, "\t")))
+		for _, r := range rows {
+			fmt.Fprintln(w, strings.Join(r, "\t"))
+		}
+		return w.Flush()
+	case "csv":
+		w := csv.NewWriter(os.Stdout)
+		w.Write(header)
+		w.WriteAll(rows)
+		return w.Error()
+	}
+	return pkg.PrintStructured(os.Stdout, format, v)
+}
+
+var imagesCmd = &cobra.Command{
+	Use:   "images",
+	Short: "Inspect and relocate the container images used by Knative components and build templates.",
+}
+
+// imageUse is a single image reported by `knuts images list`.
+type imageUse struct {
+	Image  string `json:"image"`
+	Digest string `json:"digest,omitempty"`
+	// UsedBy lists the components and build templates which use Image.
+	UsedBy []string `json:"usedBy"`
+}
+
+var imagesListCmd = &cobra.Command{
+	Use:     "list",
+	Aliases: []string{"ls"},
+	Short:   "List the container images used by the selected components and build templates.",
+	Run: func(cmd *cobra.Command, args []string) {
+		sources, err := selectedManifests(cmd)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(2)
+		}
+		found := map[string]*imageUse{}
+		for _, s := range sources {
+			contents, err := pkg.Fetch(s.URL, s.Digest)
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+			refs, err := images.Find(contents)
+			if err != nil {
+				fmt.Printf("%s: %v\n", s.URL, err)
+				os.Exit(1)
+			}
+			for _, r := range refs {
+				u, ok := found[r.Image]
+				if !ok {
+					u = &imageUse{Image: r.Image, Digest: images.Digest(r.Image)}
+					found[r.Image] = u
+				}
+				if len(u.UsedBy) == 0 || u.UsedBy[len(u.UsedBy)-1] != s.Name {
+					u.UsedBy = append(u.UsedBy, s.Name)
+				}
+			}
+		}
+
+		out := []imageUse{}
+		for _, u := range found {
+			out = append(out, *u)
+		}
+		sort.Slice(out, func(i, j int) bool { return out[i].Image < out[j].Image })
+		rows := [][]string{}
+		for _, u := range out {
+			rows = append(rows, []string{u.Image, u.Digest, strings.Join(u.UsedBy, ",")})
+		}
+		if err := printRows(imagesFormat.String(), []string{"image", "digest", "used by"}, rows, out); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	},
+}
+
+// relocation is a single image copy reported by `knuts images relocate`.
+type relocation struct {
+	Source      string `json:"source"`
+	Destination string `json:"destination"`
+}
+
+var imagesRelocateCmd = &cobra.Command{
+	Use:   "relocate",
+	Short: "Report where each image would be pulled from after relocating to --registry.",
+	Long: `Report where each image would be pulled from after relocating to --registry.
+
+Copy each source image to its destination, then install with the same
+--image-registry to rewrite the manifests as they are applied:
+
+  knuts images relocate --registry registry.internal/knative --components serving -o csv
+  knuts install --image-registry registry.internal/knative --components serving`,
+	Run: func(cmd *cobra.Command, args []string) {
+		sources, err := selectedManifests(cmd)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(2)
+		}
+		mapping := map[string]string{}
+		for _, s := range sources {
+			contents, err := pkg.Fetch(s.URL, s.Digest)
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+			_, m, err := images.Relocate(contents, relocateRegistry)
+			if err != nil {
+				fmt.Printf("%s: %v\n", s.URL, err)
+				os.Exit(1)
+			}
+			for k, v := range m {
+				mapping[k] = v
+			}
+		}
+
+		out := []relocation{}
+		for k, v := range mapping {
+			out = append(out, relocation{Source: k, Destination: v})
+		}
+		sort.Slice(out, func(i, j int) bool { return out[i].Source < out[j].Source })
+		rows := [][]string{}
+		for _, r := range out {
+			rows = append(rows, []string{r.Source, r.Destination})
+		}
+		if err := printRows(imagesFormat.String(), []string{"source", "destination"}, rows, out); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	},
+}
@@ -3,8 +3,13 @@ package cmd
 import (
 	"fmt"
 	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
 
 	"github.com/evankanderson/knuts/pkg/install"
+	"github.com/evankanderson/knuts/pkg/lock"
+	"github.com/evankanderson/knuts/pkg/overlay"
 
 	"github.com/evankanderson/knuts/pkg"
 	"github.com/spf13/cobra"
@@ -13,10 +18,41 @@ import (
 func init() {
 	rootCmd.AddCommand(installCmd)
 	installCmd.PersistentFlags().Var(&componentsFlag, "components", componentsFlag.Description)
+	installCmd.PersistentFlags().Var(&versionFlag, "version", versionFlag.Description)
+	installCmd.PersistentFlags().StringToStringVar(&prefer, "prefer", nil, "Provider to use for a virtual dependency, e.g. ingress=istio-lean. May be repeated.")
+	installCmd.PersistentFlags().StringVar(&catalogPath, "catalog", "", "YAML or JSON component catalog to use instead of the built-in one.")
+	installCmd.Flags().StringVar(&bundlePath, "bundle", "", "Install from a bundle created by `knuts bundle create` instead of downloading manifests.")
+	installCmd.Flags().DurationVar(&waitTimeout, "timeout", 5*time.Minute, "How long to wait for each component to become ready before installing the next.")
+	installCmd.Flags().BoolVar(&showPlan, "plan", false, "Print the resolved install plan instead of installing.")
+	installCmd.Flags().VarP(&planFormat, "output", "o", planFormat.Description())
+	installCmd.Flags().StringVar(&overlayDir, "overlay", "", "Directory of strategic merge or JSON patches to apply to manifests before installing them.")
+	installCmd.Flags().StringVar(&pkg.Default.ImageRegistry, "image-registry", "", "Pull all images from this registry mirror instead, e.g. registry.internal/knative. See `knuts images relocate`.")
+	installCmd.Flags().StringVar(&staticIP, "static-ip", "", "Reserve (or reuse) a GCP regional static IP with this name for the ingress gateway.")
+	installCmd.Flags().StringVar(&gcpRegion, "region", "", "GCP region for --static-ip, which must match the cluster's.")
+	installCmd.Flags().Var(&gcpProject, "gcp_project", "GCP Project for --static-ip")
+	installCmd.Flags().StringVar(&lockPath, "lockfile", lock.DefaultPath, "Lockfile recording exactly which manifests were installed.")
+	installCmd.Flags().BoolVar(&skipChecks, "skip-checks", false, "Install without running the preflight checks from `knuts doctor`.")
+	installCmd.Flags().BoolVar(&locked, "locked", false, "Install exactly the components recorded in --lockfile, rather than resolving them from the catalog.")
+	installCmd.Flags().StringSliceVar(&contexts, "contexts", nil, "Install to each of these kubeconfig contexts, in parallel, instead of the current one.")
+	installCmd.Flags().StringVar(&fleetPath, "fleet", "", "Install to each cluster listed in this fleet file, in parallel, instead of the current one.")
+	installCmd.Flags().IntVar(&concurrency, "concurrency", 4, "How many clusters to install to at once, with --contexts or --fleet.")
 }
 
 var (
-	componentsFlag = install.ComponentsAsFlag()
+	// versionFlag and componentsFlag have no Options until the catalog
+	// has been loaded.
+	versionFlag = pkg.Select{
+		Description: "Which Knative release to install",
+	}
+	componentsFlag = pkg.MultiSelect{
+		Description: "Which components to install",
+	}
+	catalogPath string
+	overlayDir  string
+	prefer      map[string]string
+	showPlan    bool
+	waitTimeout time.Duration
+	planFormat  = pkg.Output{Formats: []string{"table", "json", "yaml"}}
 )
 
 var installCmd = &cobra.Command{
@@ -24,18 +60,196 @@ var installCmd = &cobra.Command{
 	Aliases: []string{"in", "knstall"},
 	Short:   "Menu-guided install of Knative components.",
 	Run: func(cmd *cobra.Command, args []string) {
-		if err := pkg.Installed("kubectl"); err != nil {
-			fmt.Print(err)
+		plan, release, err := installPlan(cmd)
+		if err != nil {
+			fmt.Println(err)
 			os.Exit(2)
 		}
-		selected := componentsFlag.Get().([]pkg.Option)
-		work := []install.Component{}
-		for _, o := range selected {
-			component := o.Data.(install.Component)
-			work = component.Expand(work)
+		if showPlan {
+			if err := printPlan(release, plan); err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+			return
 		}
-		for _, w := range work {
-			pkg.Kubectl(w.Yaml, os.Stdout)
+
+		if len(contexts) > 0 || fleetPath != "" {
+			installFleet(plan, release)
+			return
+		}
+
+		if err := pkg.Default.Access(); err != nil {
+			fmt.Println(err)
+			os.Exit(2)
+		}
+		if err := useBundle(); err != nil {
+			fmt.Println(err)
+			os.Exit(2)
+		}
+		defer closeBundle()
+		if err := preflight(installChecks(pkg.Default, plan)); err != nil {
+			fmt.Println(err)
+			os.Exit(2)
+		}
+		if err := useOverlay(pkg.Default); err != nil {
+			fmt.Println(err)
+			os.Exit(2)
+		}
+		ip, err := useStaticIP(cmd, pkg.Default, plan)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		if err := installTo(pkg.Default, plan, ip); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		if !locked && !pkg.Default.DryRun {
+			if err := lockComponents(release, plan); err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+			fmt.Printf("Recorded installed components in %s\n", lockPath)
 		}
 	},
 }
+
+// installTo installs the steps of plan to cluster, in order, waiting for
+// each to be ready. If ip is set, the ingress gateway is given that
+// address (see useStaticIP).
+func installTo(cluster *pkg.Cluster, plan *install.Plan, ip string) error {
+	plan.Configure(cluster)
+	for _, s := range plan.Steps {
+		if err := s.Component.Install(cluster); err != nil {
+			return fmt.Errorf("Failed to install %s: %v", s.Name, err)
+		}
+		if err := s.Component.WaitReady(cluster, waitTimeout); err != nil {
+			return err
+		}
+	}
+	if ip != "" {
+		if err := patchIngressIP(cluster, plan.Gateway(), ip); err != nil {
+			return fmt.Errorf("Failed to set the ingress gateway's IP to %s: %v", ip, err)
+		}
+	}
+	if err := plan.PatchConfig(cluster); err != nil {
+		return err
+	}
+	warnUnusedOverlay(cluster)
+	return nil
+}
+
+// useRelease loads the catalog and release selected by flags, prompting
+// for the release if needed.
+func useRelease() error {
+	if err := loadCatalog(); err != nil {
+		return err
+	}
+	return selectRelease()
+}
+
+// loadCatalog loads the catalog selected by --catalog, if any.
+func loadCatalog() error {
+	if catalogPath == "" {
+		return nil
+	}
+	return install.LoadCatalog(catalogPath)
+}
+
+// selectRelease uses the release selected by --version, prompting if
+// needed. Without a terminal to prompt on, --version is required.
+func selectRelease() error {
+	if err := versionFlag.SetOptions(install.VersionsAsFlag().Options); err != nil {
+		return err
+	}
+	if versionFlag.String() == "" && !pkg.Interactive() {
+		return fmt.Errorf("--version is required when not running interactively (the latest release is %s)", install.Latest())
+	}
+	return install.UseRelease(versionFlag.Selected())
+}
+
+// resolvePlan loads the catalog and release selected by flags (prompting if
+// needed) and resolves the selected components.
+func resolvePlan() (*install.Plan, error) {
+	if err := useRelease(); err != nil {
+		return nil, err
+	}
+	if err := componentsFlag.SetOptions(install.ComponentsAsFlag().Options); err != nil {
+		return nil, err
+	}
+	selected := []string{}
+	for _, o := range componentsFlag.Get().([]pkg.Option) {
+		selected = append(selected, o.Data.(install.Component).Name)
+	}
+	resolver := install.DefaultResolver()
+	if err := resolver.Prefer(prefer); err != nil {
+		return nil, err
+	}
+	return resolver.Resolve(selected)
+}
+
+// useOverlay makes cluster apply the patches in --overlay, if set.
+func useOverlay(cluster *pkg.Cluster) error {
+	if overlayDir == "" {
+		return nil
+	}
+	o, err := overlay.Load(overlayDir)
+	if err != nil {
+		return err
+	}
+	cluster.Overlay = o
+	return nil
+}
+
+// warnUnusedOverlay reports any patches from useOverlay which did not
+// match an object installed to cluster, since they are likely mistakes.
+func warnUnusedOverlay(cluster *pkg.Cluster) {
+	if cluster.Overlay == nil {
+		return
+	}
+	for _, p := range cluster.Overlay.Unused() {
+		fmt.Fprintf(cluster.Output(), "WARNING: patch for %s did not match any object\n", p)
+	}
+}
+
+// installPlan returns the plan to install and the release it is from,
+// either from the lockfile if --locked is set or resolved from the catalog.
+func installPlan(cmd *cobra.Command) (*install.Plan, string, error) {
+	if locked {
+		if err := checkLocked(cmd, "components", "version", "prefer", "catalog", "image-registry"); err != nil {
+			return nil, "", err
+		}
+		return lockedPlan()
+	}
+	plan, err := resolvePlan()
+	return plan, versionFlag.String(), err
+}
+
+// planOutput is the structured form of a plan printed by --plan.
+type planOutput struct {
+	Version      string `json:"version" yaml:"version"`
+	install.Plan `yaml:",inline"`
+}
+
+func printPlan(release string, plan *install.Plan) error {
+	if f := planFormat.String(); f != "table" {
+		return pkg.PrintStructured(os.Stdout, f, planOutput{Version: release, Plan: *plan})
+	}
+	fmt.Printf("Knative release %s\n\n", release)
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "STEP\tCOMPONENT\tREQUIRED BY\tMANIFEST")
+	for i, s := range plan.Steps {
+		by := s.RequiredBy
+		if s.Requested {
+			by = append([]string{"(requested)"}, by...)
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\n", i+1, s.Name, strings.Join(by, ","), s.Manifest)
+	}
+	if len(plan.Providers) > 0 {
+		fmt.Fprintln(w, "\nDEPENDENCY\tPROVIDER\tREASON")
+		for _, p := range plan.Providers {
+			fmt.Fprintf(w, "%s\t%s\t%s\n", p.Dependency, p.Provider, p.Reason)
+		}
+	}
+	return w.Flush()
+}
@@ -0,0 +1,138 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/evankanderson/knuts/pkg"
+	"github.com/evankanderson/knuts/pkg/builds"
+	"github.com/evankanderson/knuts/pkg/install"
+	"github.com/evankanderson/knuts/pkg/lock"
+	"github.com/spf13/cobra"
+)
+
+var (
+	// lockPath is the lockfile written by install, upgrade and builds, and
+	// read by --locked.
+	lockPath string
+	locked   bool
+)
+
+// checkLocked returns an error if any of the named flags were set along
+// with --locked, since the lockfile determines what is installed.
+func checkLocked(cmd *cobra.Command, flags ...string) error {
+	for _, f := range flags {
+		if cmd.Flags().Changed(f) {
+			return fmt.Errorf("--locked installs exactly what is in %s; it cannot be combined with --%s", lockPath, f)
+		}
+	}
+	return nil
+}
+
+// lockedPlan returns the install plan recorded in the lockfile, and the
+// release it was resolved from. It also uses the lockfile's image registry.
+func lockedPlan() (*install.Plan, string, error) {
+	l, err := lock.Read(lockPath)
+	if err != nil {
+		return nil, "", fmt.Errorf("Unable to read lockfile: %v", err)
+	}
+	pkg.Default.ImageRegistry = l.ImageRegistry
+	if len(l.Components) == 0 {
+		return nil, "", fmt.Errorf("%s does not contain any components", lockPath)
+	}
+	plan := &install.Plan{Providers: []install.Resolution{}}
+	for _, c := range l.Components {
+		plan.Steps = append(plan.Steps, install.Step{
+			Component: install.Component{
+				Name:       c.Name,
+				Version:    c.Version,
+				Yaml:       c.URL,
+				Digest:     c.SHA256,
+				Namespaces: c.Namespaces,
+				Config:     c.Config,
+				Kubernetes: c.Kubernetes,
+				Gateway:    c.Gateway,
+			},
+			Name:      c.Name,
+			Manifest:  c.URL,
+			Digest:    c.SHA256,
+			Requested: true,
+			Config:    c.Config,
+		})
+	}
+	for _, p := range l.Providers {
+		plan.Providers = append(plan.Providers, install.Resolution{Dependency: p.Dependency, Provider: p.Provider, Reason: install.ReasonLocked})
+	}
+	return plan, l.Release, nil
+}
+
+// lockComponents records the installed steps of plan in the lockfile. The
+// digests are of the manifests which were actually applied, so unpinned
+// manifests are pinned by the lockfile.
+func lockComponents(release string, plan *install.Plan) error {
+	entries := []lock.Component{}
+	for _, s := range plan.Steps {
+		contents, err := pkg.Fetch(s.Manifest, s.Component.Digest)
+		if err != nil {
+			return err
+		}
+		entries = append(entries, lock.Component{
+			Name:       s.Name,
+			Version:    s.Component.Version,
+			URL:        s.Manifest,
+			SHA256:     pkg.Digest(contents),
+			Namespaces: s.Component.Namespaces,
+			Config:     s.Component.Config,
+			Kubernetes: s.Component.Kubernetes,
+			Gateway:    s.Component.Gateway,
+		})
+	}
+	return lock.Update(lockPath, func(l *lock.Lock) {
+		l.SetRelease(release)
+		l.ImageRegistry = pkg.Default.ImageRegistry
+		for _, p := range plan.Providers {
+			l.SetProvider(lock.Provider{Dependency: p.Dependency, Provider: p.Provider})
+		}
+		for _, c := range entries {
+			l.SetComponent(c)
+		}
+	})
+}
+
+// lockedTemplates returns the build templates recorded in the lockfile. It
+// also uses the lockfile's image registry.
+func lockedTemplates() ([]builds.Template, error) {
+	l, err := lock.Read(lockPath)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read lockfile: %v", err)
+	}
+	pkg.Default.ImageRegistry = l.ImageRegistry
+	ret := []builds.Template{}
+	for _, t := range l.Templates {
+		ret = append(ret, builds.Template{Name: t.Name, URL: t.URL, Digest: t.SHA256})
+	}
+	return ret, nil
+}
+
+// lockTemplates records the installed build templates in the lockfile,
+// along with the commit each was pinned to (if any).
+func lockTemplates(templates []builds.Template, commits map[string]string) error {
+	entries := []lock.Template{}
+	for _, t := range templates {
+		contents, err := pkg.Fetch(t.URL, t.Digest)
+		if err != nil {
+			return err
+		}
+		entries = append(entries, lock.Template{
+			Name:   t.Name,
+			URL:    t.URL,
+			Commit: commits[t.Name],
+			SHA256: pkg.Digest(contents),
+		})
+	}
+	return lock.Update(lockPath, func(l *lock.Lock) {
+		l.ImageRegistry = pkg.Default.ImageRegistry
+		for _, t := range entries {
+			l.SetTemplate(t)
+		}
+	})
+}
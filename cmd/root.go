@@ -5,6 +5,7 @@ import (
 	"os"
 
 	"github.com/evankanderson/knuts/pkg"
+	"github.com/evankanderson/knuts/pkg/gcp"
 
 	"github.com/spf13/cobra"
 )
@@ -16,7 +17,13 @@ var rootCmd = &cobra.Command{
 }
 
 func init() {
-	rootCmd.PersistentFlags().BoolVar(&pkg.DryRun, "dry_run", true, "When true, print operations rather than executing them.")
+	rootCmd.PersistentFlags().BoolVar(&pkg.Default.DryRun, "dry_run", true, "When true, print operations rather than executing them.")
+	rootCmd.PersistentFlags().BoolVar(&pkg.AllowUnverified, "allow-unverified", false, "Allow manifests which have no pinned sha256 digest, e.g. from a custom catalog.")
+	rootCmd.PersistentFlags().StringVar(&pkg.Default.Kubeconfig, "kubeconfig", "", "Path to the kubeconfig file to use, rather than kubectl's default.")
+	rootCmd.PersistentFlags().StringVar(&pkg.Default.Context, "context", "", "The kubeconfig context to use, rather than the current context.")
+	rootCmd.PersistentFlags().BoolVar(&pkg.Default.UseKubectl, "kubectl", false, "Talk to the cluster by running kubectl, rather than with the built-in client.")
+	rootCmd.PersistentFlags().StringVar(&gcp.Endpoint, "gcp_endpoint", "", "Send Google Cloud API calls to this URL without credentials, e.g. a local fake for testing.")
+	rootCmd.PersistentFlags().MarkHidden("gcp_endpoint")
 	// rootCmd.PersistentFlags().StringVar(&pkg.GCPProject, "gcp_project", "", "GCP Project to use for GCP operations")
 }
 
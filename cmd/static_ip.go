@@ -0,0 +1,72 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/evankanderson/knuts/pkg"
+	"github.com/evankanderson/knuts/pkg/gcp"
+	"github.com/evankanderson/knuts/pkg/install"
+	"github.com/evankanderson/knuts/pkg/overlay"
+	"github.com/spf13/cobra"
+	yaml "gopkg.in/yaml.v2"
+)
+
+var (
+	staticIP  string
+	gcpRegion string
+)
+
+// ingressTarget is the overlay Target for the gateway Service gw.
+func ingressTarget(gw *install.Gateway) overlay.Target {
+	return overlay.Target{Kind: "Service", Namespace: gw.Namespace, Name: gw.Name}
+}
+
+// useStaticIP reserves the regional address named by --static-ip, if set,
+// and patches the gateway Service of plan's ingress provider in cluster to
+// use it as it is installed. It returns the address.
+func useStaticIP(cmd *cobra.Command, cluster *pkg.Cluster, plan *install.Plan) (string, error) {
+	if staticIP == "" {
+		return "", nil
+	}
+	if gcpRegion == "" {
+		return "", fmt.Errorf("--static-ip requires --region")
+	}
+	gw := plan.Gateway()
+	if gw == nil {
+		return "", fmt.Errorf("--static-ip needs an ingress provider among the components to install")
+	}
+	client, project, err := gcp.Client(context.Background())
+	if err != nil {
+		return "", err
+	}
+	if cmd.Flags().Changed("gcp_project") || project == "" {
+		project = gcpProject.Get().(string)
+	}
+	ip, err := gcp.ReserveAddress(client, project, gcpRegion, staticIP)
+	if err != nil {
+		return "", err
+	}
+	if cluster.Overlay == nil {
+		cluster.Overlay = &overlay.Overlay{}
+	}
+	cluster.Overlay.Add(ingressTarget(gw), yaml.MapSlice{
+		{Key: "spec", Value: yaml.MapSlice{{Key: "loadBalancerIP", Value: ip}}},
+	})
+	return ip, nil
+}
+
+// patchIngressIP sets the address of the gateway Service gw to ip, if it
+// was not already set by useStaticIP because the gateway was installed
+// earlier.
+func patchIngressIP(cluster *pkg.Cluster, gw *install.Gateway, ip string) error {
+	if cluster.Overlay.Used(ingressTarget(gw)) {
+		return nil
+	}
+	patch, err := json.Marshal(map[string]interface{}{"spec": map[string]string{"loadBalancerIP": ip}})
+	if err != nil {
+		return err
+	}
+	return cluster.Patch("service", gw.Namespace, gw.Name, patch)
+}
@@ -0,0 +1,143 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/evankanderson/knuts/pkg"
+	"github.com/evankanderson/knuts/pkg/builds"
+	"github.com/evankanderson/knuts/pkg/install"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	rootCmd.AddCommand(statusCmd)
+	statusCmd.PersistentFlags().Var(&versionFlag, "version", "Which Knative release's catalog to check (default: newest)")
+	statusCmd.PersistentFlags().StringVar(&catalogPath, "catalog", "", "YAML or JSON component catalog to use instead of the built-in one.")
+	statusCmd.Flags().VarP(&statusFormat, "output", "o", statusFormat.Description())
+}
+
+var statusFormat = pkg.Output{Formats: []string{"table", "json", "yaml"}}
+
+// objectStatus records whether a named object exists in the cluster.
+type objectStatus struct {
+	Name      string `json:"name" yaml:"name"`
+	Installed bool   `json:"installed" yaml:"installed"`
+}
+
+// statusOutput is the structured form of `knuts status`.
+type statusOutput struct {
+	Components     []install.ComponentStatus `json:"components" yaml:"components"`
+	BuildTemplates []objectStatus            `json:"buildTemplates" yaml:"buildTemplates"`
+	Secrets        []objectStatus            `json:"secrets" yaml:"secrets"`
+	ServiceAccount objectStatus              `json:"serviceAccount" yaml:"serviceAccount"`
+}
+
+var statusCmd = &cobra.Command{
+	Use:     "status",
+	Aliases: []string{"st"},
+	Short:   "Report which Knative components and build resources are installed.",
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := pkg.Default.Access(); err != nil {
+			fmt.Println(err)
+			os.Exit(2)
+		}
+		if err := loadCatalog(); err != nil {
+			fmt.Println(err)
+			os.Exit(2)
+		}
+		if !cmd.Flags().Changed("version") {
+			versionFlag.Set(install.Latest())
+		}
+		if err := selectRelease(); err != nil {
+			fmt.Println(err)
+			os.Exit(2)
+		}
+		out, err := clusterStatus()
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		if err := printStatus(out); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	},
+}
+
+func clusterStatus() (*statusOutput, error) {
+	out := &statusOutput{}
+	for _, c := range install.Components() {
+		s, err := c.Status(pkg.Default)
+		if err != nil {
+			return nil, err
+		}
+		out.Components = append(out.Components, s)
+	}
+
+	names := []string{}
+	for k := range builds.Builds.Options {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	for _, n := range names {
+		ok, err := builds.BuildTemplate(builds.Builds.Options[n]).Installed(pkg.Default)
+		if err != nil {
+			return nil, err
+		}
+		out.BuildTemplates = append(out.BuildTemplates, objectStatus{Name: n, Installed: ok})
+	}
+
+	for _, n := range []string{builds.DockerHubProvider, builds.GCRProvider} {
+		ok, err := pkg.Default.ObjectExists("secret", n)
+		if err != nil {
+			return nil, err
+		}
+		out.Secrets = append(out.Secrets, objectStatus{Name: n, Installed: ok})
+	}
+	ok, err := pkg.Default.ObjectExists("serviceaccount", builds.ServiceAccount)
+	if err != nil {
+		return nil, err
+	}
+	out.ServiceAccount = objectStatus{Name: builds.ServiceAccount, Installed: ok}
+	return out, nil
+}
+
+func printStatus(out *statusOutput) error {
+	if f := statusFormat.String(); f != "table" {
+		return pkg.PrintStructured(os.Stdout, f, out)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "COMPONENT\tINSTALLED\tVERSION\tDEPLOYMENTS\tHEALTHY")
+	for _, c := range out.Components {
+		if !c.Installed {
+			fmt.Fprintf(w, "%s\tno\n", c.Name)
+			continue
+		}
+		version := c.Version
+		if version == "" {
+			version = "unknown"
+		}
+		fmt.Fprintf(w, "%s\tyes\t%s\t%d/%d\t%s\n", c.Name, version, c.Available, c.Deployments, yesNo(c.Healthy))
+	}
+	fmt.Fprintln(w, "\nBUILD TEMPLATE\tINSTALLED")
+	for _, b := range out.BuildTemplates {
+		fmt.Fprintf(w, "%s\t%s\n", b.Name, yesNo(b.Installed))
+	}
+	fmt.Fprintln(w, "\nREGISTRY SECRET\tINSTALLED")
+	for _, s := range out.Secrets {
+		fmt.Fprintf(w, "%s\t%s\n", s.Name, yesNo(s.Installed))
+	}
+	fmt.Fprintln(w, "\nSERVICE ACCOUNT\tINSTALLED")
+	fmt.Fprintf(w, "%s\t%s\n", out.ServiceAccount.Name, yesNo(out.ServiceAccount.Installed))
+	return w.Flush()
+}
+
+func yesNo(b bool) string {
+	if b {
+		return "yes"
+	}
+	return "no"
+}
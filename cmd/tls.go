@@ -0,0 +1,161 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/evankanderson/knuts/pkg"
+	"github.com/evankanderson/knuts/pkg/certs"
+	"github.com/evankanderson/knuts/pkg/domain"
+	"github.com/evankanderson/knuts/pkg/install"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	rootCmd.AddCommand(tlsCmd)
+	tlsCmd.Flags().Var(&versionFlag, "version", "Which Knative release's catalog to install cert-manager from (default: newest)")
+	tlsCmd.Flags().StringVar(&catalogPath, "catalog", "", "YAML or JSON component catalog to use instead of the built-in one.")
+	tlsCmd.Flags().DurationVar(&waitTimeout, "timeout", 5*time.Minute, "How long to wait for each component to become ready.")
+	tlsCmd.Flags().StringVar(&tlsOptions.Issuer, "issuer", certs.SelfSigned, "How to issue the certificate, one of: "+strings.Join(certs.Issuers, ", ")+".")
+	tlsCmd.Flags().StringVar(&tlsOptions.Email, "email", "", "Email address for the ACME account.")
+	tlsCmd.Flags().StringVar(&tlsOptions.Server, "acme-server", certs.LetsEncrypt, "ACME server to request the certificate from.")
+	tlsCmd.Flags().StringVar(&tlsOptions.Project, "gcp_project", "", "GCP project hosting the Cloud DNS zone, for acme-dns01.")
+	tlsCmd.Flags().StringVar(&tlsOptions.ServiceAccountSecret, "dns01-secret", "", "Secret in the cert-manager namespace holding a Cloud DNS service account key as key.json, for acme-dns01.")
+	tlsCmd.Flags().StringVar(&dnsDomain, "domain", "", "Domain to request a certificate for (default: the configured default domain).")
+	tlsCmd.Flags().StringSliceVar(&tlsNamespaces, "namespace", []string{"default"}, "Namespaces whose Services the wildcard certificate should cover. May be repeated.")
+	tlsCmd.Flags().StringSliceVar(&tlsOptions.Hosts, "hosts", nil, "Exact hostnames to request instead of wildcards, e.g. for acme-http01.")
+}
+
+var (
+	tlsOptions    certs.Options
+	tlsNamespaces []string
+)
+
+var tlsCmd = &cobra.Command{
+	Use:   "tls",
+	Short: "Serve Knative routes over HTTPS, with a certificate from cert-manager.",
+	Long: `Serve Knative routes over HTTPS, with a certificate from cert-manager.
+
+Installs cert-manager (and its dependencies) if needed, creates a
+ClusterIssuer and requests a wildcard certificate for *.NAMESPACE.DOMAIN
+for each --namespace, and adds an HTTPS server using it to the Knative
+ingress gateway.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := pkg.Default.Access(); err != nil {
+			fmt.Println(err)
+			os.Exit(2)
+		}
+		if len(tlsOptions.Hosts) == 0 {
+			name := dnsDomain
+			if name == "" {
+				config, err := domain.Get()
+				if err != nil {
+					fmt.Println(err)
+					os.Exit(1)
+				}
+				if name = config.Default(); name == domain.DefaultDomain {
+					fmt.Println("No domain is configured; use `knuts domain set` or --domain")
+					os.Exit(2)
+				}
+			}
+			for _, ns := range tlsNamespaces {
+				tlsOptions.Hosts = append(tlsOptions.Hosts, domain.Host("*", ns, name))
+			}
+		}
+		certManifest, err := certs.Manifest(tlsOptions)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(2)
+		}
+
+		if err := loadCatalog(); err != nil {
+			fmt.Println(err)
+			os.Exit(2)
+		}
+		if !cmd.Flags().Changed("version") {
+			versionFlag.Set(install.Latest())
+		}
+		if err := selectRelease(); err != nil {
+			fmt.Println(err)
+			os.Exit(2)
+		}
+		plan, installed, err := resolveMissing("cert-manager")
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		if err := requireIstio(plan); err != nil {
+			fmt.Println(err)
+			os.Exit(2)
+		}
+		if err := installMissing(plan, installed); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		if pkg.Default.DryRun {
+			fmt.Printf("%s\n", certManifest)
+		}
+		if err := pkg.Default.KubectlInline(certManifest); err != nil {
+			fmt.Printf("Failed to request a certificate: %v\n", err)
+			os.Exit(1)
+		}
+		if err := certs.EnableHTTPS(); err != nil {
+			fmt.Printf("Failed to enable HTTPS on %s: %v\n", certs.GatewayName, err)
+			os.Exit(1)
+		}
+		fmt.Printf("Requested a certificate for %s; cert-manager will store it in %s once issued.\n", strings.Join(tlsOptions.Hosts, ", "), certs.SecretName)
+	},
+}
+
+// resolveMissing resolves the named components in the current release,
+// preferring providers which are already installed, and returns the Plan
+// along with which of the release's components are installed.
+func resolveMissing(names ...string) (*install.Plan, map[string]bool, error) {
+	installed := map[string]bool{}
+	for _, c := range install.Components() {
+		ok, err := c.Installed(pkg.Default)
+		if err != nil {
+			return nil, nil, err
+		}
+		installed[c.Name] = ok
+	}
+	resolver := install.DefaultResolver()
+	resolver.PreferInstalled(installed)
+	plan, err := resolver.Resolve(names)
+	if err != nil {
+		return nil, nil, err
+	}
+	return plan, installed, nil
+}
+
+// requireIstio checks that plan uses Istio for ingress, since the
+// certificate is served by the Istio ingress gateway.
+func requireIstio(plan *install.Plan) error {
+	for _, p := range plan.Providers {
+		if p.Dependency == "ingress" && !strings.HasPrefix(p.Provider, "istio") {
+			return fmt.Errorf("knuts tls needs Istio for ingress, but %s provides it (%s)", p.Provider, p.Reason)
+		}
+	}
+	return nil
+}
+
+// installMissing installs the components in plan (or their dependencies)
+// which are not installed.
+func installMissing(plan *install.Plan, installed map[string]bool) error {
+	plan.Configure(pkg.Default)
+	for _, s := range plan.Steps {
+		if installed[s.Name] {
+			continue
+		}
+		if err := s.Component.Install(pkg.Default); err != nil {
+			return fmt.Errorf("Failed to install %s: %v", s.Name, err)
+		}
+		if err := s.Component.WaitReady(pkg.Default, waitTimeout); err != nil {
+			return err
+		}
+	}
+	return plan.PatchConfig(pkg.Default)
+}
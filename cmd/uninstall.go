@@ -0,0 +1,97 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/evankanderson/knuts/pkg"
+	"github.com/evankanderson/knuts/pkg/install"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	rootCmd.AddCommand(uninstallCmd)
+	uninstallCmd.PersistentFlags().Var(&removeFlag, "components", removeFlag.Description)
+	uninstallCmd.PersistentFlags().Var(&versionFlag, "version", "Which Knative release is installed")
+	uninstallCmd.PersistentFlags().StringVar(&catalogPath, "catalog", "", "YAML or JSON component catalog to use instead of the built-in one.")
+	uninstallCmd.Flags().BoolVar(&forceUninstall, "force", false, "Remove components even if other installed components depend on them.")
+}
+
+var (
+	// removeFlag has no Options until the catalog has been loaded.
+	removeFlag = pkg.MultiSelect{
+		Description: "Which components to uninstall",
+	}
+	forceUninstall bool
+)
+
+var uninstallCmd = &cobra.Command{
+	Use:     "uninstall",
+	Aliases: []string{"un", "remove"},
+	Short:   "Menu-guided removal of Knative components.",
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := pkg.Default.Access(); err != nil {
+			fmt.Println(err)
+			os.Exit(2)
+		}
+		if err := useRelease(); err != nil {
+			fmt.Println(err)
+			os.Exit(2)
+		}
+
+		installed := map[string]bool{}
+		options := map[string]pkg.Option{}
+		for _, c := range install.Components() {
+			ok, err := c.Installed(pkg.Default)
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+			installed[c.Name] = ok
+			options[c.Name] = pkg.Option{Description: c.Description, Data: c}
+		}
+		if err := removeFlag.SetOptions(options); err != nil {
+			fmt.Println(err)
+			os.Exit(2)
+		}
+		selected := []string{}
+		for _, o := range removeFlag.Get().([]pkg.Option) {
+			selected = append(selected, o.Data.(install.Component).Name)
+		}
+
+		resolver := install.NewResolver(install.Components(), nil)
+		order, err := resolver.RemovalOrder(selected)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(2)
+		}
+		dependents := resolver.Dependents(selected, installed)
+		if len(dependents) > 0 {
+			names := []string{}
+			for n := range dependents {
+				names = append(names, n)
+			}
+			sort.Strings(names)
+			for _, n := range names {
+				fmt.Printf("%s is still needed by %s\n", n, strings.Join(dependents[n], ", "))
+			}
+			if !forceUninstall {
+				fmt.Println("Refusing to uninstall; use --force to remove anyway.")
+				os.Exit(2)
+			}
+		}
+
+		for _, c := range order {
+			if !installed[c.Name] {
+				fmt.Printf("Skipping %s: not installed\n", c.Name)
+				continue
+			}
+			if err := c.Uninstall(pkg.Default); err != nil {
+				fmt.Printf("Failed to uninstall %s: %v\n", c.Name, err)
+				os.Exit(1)
+			}
+		}
+	},
+}
@@ -0,0 +1,162 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/evankanderson/knuts/pkg"
+	"github.com/evankanderson/knuts/pkg/install"
+	"github.com/evankanderson/knuts/pkg/lock"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	rootCmd.AddCommand(upgradeCmd)
+	upgradeCmd.PersistentFlags().Var(&versionFlag, "to", "Which Knative release to upgrade to")
+	upgradeCmd.PersistentFlags().Var(&componentsFlag, "components", "Which components to upgrade (default: all installed components)")
+	upgradeCmd.PersistentFlags().StringToStringVar(&prefer, "prefer", nil, "Provider to use for a virtual dependency, e.g. ingress=istio-lean. May be repeated.")
+	upgradeCmd.PersistentFlags().StringVar(&catalogPath, "catalog", "", "YAML or JSON component catalog to use instead of the built-in one.")
+	upgradeCmd.Flags().DurationVar(&waitTimeout, "timeout", 5*time.Minute, "How long to wait for each component to become ready before upgrading the next.")
+	upgradeCmd.Flags().StringVar(&overlayDir, "overlay", "", "Directory of strategic merge or JSON patches to apply to manifests before installing them.")
+	upgradeCmd.Flags().StringVar(&pkg.Default.ImageRegistry, "image-registry", "", "Pull all images from this registry mirror instead, e.g. registry.internal/knative. See `knuts images relocate`.")
+	upgradeCmd.Flags().StringVar(&lockPath, "lockfile", lock.DefaultPath, "Lockfile recording exactly which manifests are installed.")
+}
+
+var upgradeCmd = &cobra.Command{
+	Use:     "upgrade",
+	Aliases: []string{"up"},
+	Short:   "Upgrade installed Knative components to another release.",
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := pkg.Default.Access(); err != nil {
+			fmt.Println(err)
+			os.Exit(2)
+		}
+		if !cmd.Flags().Changed("to") && !pkg.Interactive() {
+			fmt.Println("--to is required when not running interactively")
+			os.Exit(2)
+		}
+		if err := useRelease(); err != nil {
+			fmt.Println(err)
+			os.Exit(2)
+		}
+
+		// Components are detected with the catalog entries of the installed
+		// release, since their names and manifests change between releases.
+		from, err := install.InstalledRelease(pkg.Default)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		detect := install.Components()
+		if from != nil {
+			detect = from.Components
+		}
+
+		// By default, upgrade all installed components. Hidden components
+		// are only upgraded as dependencies.
+		visible := install.ComponentsAsFlag().Options
+		installed := map[string]bool{}
+		current := map[string]string{}
+		selected := []string{}
+		for _, old := range detect {
+			ok, err := old.Installed(pkg.Default)
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+			if !ok {
+				continue
+			}
+			c, ok := install.Successor(old.Name)
+			if !ok {
+				fmt.Printf("%s: not part of %s, leaving it alone\n", old.Name, versionFlag.String())
+				continue
+			}
+			installed[c.Name] = true
+			if _, ok := visible[c.Name]; ok {
+				selected = append(selected, c.Name)
+			}
+			if current[c.Name], err = old.InstalledVersion(pkg.Default); err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+		}
+		if len(installed) == 0 {
+			fmt.Println("No Knative components found; use `knuts install` instead.")
+			os.Exit(2)
+		}
+		if cmd.Flags().Changed("components") {
+			if err := componentsFlag.SetOptions(visible); err != nil {
+				fmt.Println(err)
+				os.Exit(2)
+			}
+			selected = []string{}
+			for _, o := range componentsFlag.Get().([]pkg.Option) {
+				selected = append(selected, o.Data.(install.Component).Name)
+			}
+		}
+
+		resolver := install.DefaultResolver()
+		if err := resolver.Prefer(prefer); err != nil {
+			fmt.Println(err)
+			os.Exit(2)
+		}
+		resolver.PreferInstalled(installed)
+		plan, err := resolver.Resolve(selected)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(2)
+		}
+
+		work := []install.Component{}
+		for _, s := range plan.Steps {
+			from := current[s.Name]
+			to := s.Component.Version
+			switch {
+			case !installed[s.Name]:
+				fmt.Printf("%s: not installed, will install %s\n", s.Name, to)
+			case from == to:
+				fmt.Printf("%s: already at %s\n", s.Name, to)
+				continue
+			case from == "":
+				fmt.Printf("%s: unknown release -> %s\n", s.Name, to)
+			default:
+				fmt.Printf("%s: %s -> %s\n", s.Name, from, to)
+			}
+			if !s.Requested && installed[s.Name] {
+				fmt.Printf("  WARNING: %s must be upgraded together with %s\n", s.Name, strings.Join(s.RequiredBy, ", "))
+			}
+			work = append(work, s.Component)
+		}
+
+		if err := useOverlay(pkg.Default); err != nil {
+			fmt.Println(err)
+			os.Exit(2)
+		}
+		plan.Configure(pkg.Default)
+		for _, c := range work {
+			if err := c.Install(pkg.Default); err != nil {
+				fmt.Printf("Failed to upgrade %s: %v\n", c.Name, err)
+				os.Exit(1)
+			}
+			if err := c.WaitReady(pkg.Default, waitTimeout); err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+		}
+		if err := plan.PatchConfig(pkg.Default); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		warnUnusedOverlay(pkg.Default)
+		if pkg.Default.DryRun {
+			return
+		}
+		if err := lockComponents(versionFlag.String(), plan); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	},
+}
@@ -0,0 +1,392 @@
+// Package apply applies kubernetes manifests in-process, the way `kubectl
+// apply` does, and reports what happened to each object.
+//
+// Like kubectl, it records the applied configuration of each object in the
+// kubectl.kubernetes.io/last-applied-configuration annotation and updates
+// objects with a three-way patch, so objects may be managed by either.
+package apply
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/jsonmergepatch"
+	"k8s.io/apimachinery/pkg/util/strategicpatch"
+	"k8s.io/apimachinery/pkg/util/yaml"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/discovery/cached/memory"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/restmapper"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// LastApplied is the annotation holding the configuration an object was
+// last applied with, shared with `kubectl apply`.
+const LastApplied = "kubectl.kubernetes.io/last-applied-configuration"
+
+// Action is what happened to an object.
+type Action string
+
+// Actions reported in a Result.
+const (
+	Created    Action = "created"
+	Configured Action = "configured"
+	Unchanged  Action = "unchanged"
+	Deleted    Action = "deleted"
+	NotFound   Action = "not found"
+	Failed     Action = "failed"
+)
+
+// Result is the outcome of applying (or deleting) a single object.
+type Result struct {
+	Kind      string
+	Group     string
+	Namespace string
+	Name      string
+	Action    Action
+	// Err is set if Action is Failed.
+	Err error
+}
+
+// String formats r like kubectl, e.g. "deployment.apps/controller created".
+func (r Result) String() string {
+	s := strings.ToLower(r.Kind)
+	if r.Group != "" {
+		s += "." + r.Group
+	}
+	s += "/" + r.Name
+	if r.Namespace != "" {
+		s += " in " + r.Namespace
+	}
+	if r.Err != nil {
+		return fmt.Sprintf("%s failed: %v", s, r.Err)
+	}
+	return fmt.Sprintf("%s %s", s, r.Action)
+}
+
+// Errors returns an error listing the failed Results, or nil if none
+// failed.
+func Errors(results []Result) error {
+	failed := []string{}
+	for _, r := range results {
+		if r.Err != nil {
+			failed = append(failed, r.String())
+		}
+	}
+	if len(failed) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%d of %d objects failed: %s", len(failed), len(results), strings.Join(failed, "; "))
+}
+
+// Client applies objects to the cluster of a kubeconfig context.
+type Client struct {
+	// Host is the URL of the cluster's API server.
+	Host      string
+	dynamic   dynamic.Interface
+	discovery discovery.CachedDiscoveryInterface
+	mapper    *restmapper.DeferredDiscoveryRESTMapper
+	namespace string
+}
+
+// clientConfig loads a kubeconfig file (or the default kubeconfig, as used
+// by kubectl, including $KUBECONFIG) and selects a context (or its current
+// context).
+func clientConfig(kubeconfig string, context string) clientcmd.ClientConfig {
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	rules.ExplicitPath = kubeconfig
+	return clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, &clientcmd.ConfigOverrides{CurrentContext: context})
+}
+
+// NewClient creates a Client for a context of a kubeconfig file. Empty
+// values select the defaults, as for kubectl.
+func NewClient(kubeconfig string, context string) (*Client, error) {
+	config := clientConfig(kubeconfig, context)
+	rc, err := config.ClientConfig()
+	if err != nil {
+		return nil, fmt.Errorf("Unable to load kubeconfig: %v", err)
+	}
+	namespace, _, err := config.Namespace()
+	if err != nil {
+		return nil, fmt.Errorf("Unable to load kubeconfig: %v", err)
+	}
+	return newClient(rc, namespace)
+}
+
+// Server returns the name of a context of a kubeconfig file, and the URL of
+// its cluster's API server, without connecting to it. Empty values select
+// the defaults, as for NewClient.
+func Server(kubeconfig string, context string) (string, string, error) {
+	raw, err := clientConfig(kubeconfig, context).RawConfig()
+	if err != nil {
+		return "", "", fmt.Errorf("Unable to load kubeconfig: %v", err)
+	}
+	if context == "" {
+		context = raw.CurrentContext
+	}
+	c, ok := raw.Contexts[context]
+	if !ok {
+		return "", "", fmt.Errorf("Context %q not found in kubeconfig", context)
+	}
+	cluster, ok := raw.Clusters[c.Cluster]
+	if !ok {
+		return "", "", fmt.Errorf("Cluster %q of context %q not found in kubeconfig", c.Cluster, context)
+	}
+	return context, cluster.Server, nil
+}
+
+func newClient(rc *rest.Config, namespace string) (*Client, error) {
+	d, err := discovery.NewDiscoveryClientForConfig(rc)
+	if err != nil {
+		return nil, err
+	}
+	dc, err := dynamic.NewForConfig(rc)
+	if err != nil {
+		return nil, err
+	}
+	cached := memory.NewMemCacheClient(d)
+	return &Client{
+		Host:      rc.Host,
+		dynamic:   dc,
+		discovery: cached,
+		mapper:    restmapper.NewDeferredDiscoveryRESTMapper(cached),
+		namespace: namespace,
+	}, nil
+}
+
+// WithNamespace returns a copy of c which puts namespaced objects that do
+// not specify a namespace in namespace, rather than the kubeconfig's.
+func (c *Client) WithNamespace(namespace string) *Client {
+	copy := *c
+	copy.namespace = namespace
+	return &copy
+}
+
+// Decode parses the objects in a multi-document YAML or JSON manifest.
+// Lists are expanded into their items.
+func Decode(contents []byte) ([]*unstructured.Unstructured, error) {
+	ret := []*unstructured.Unstructured{}
+	d := yaml.NewYAMLOrJSONDecoder(bytes.NewReader(contents), 4096)
+	for {
+		o := map[string]interface{}{}
+		err := d.Decode(&o)
+		if err == io.EOF {
+			return ret, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Unable to parse manifest: %v", err)
+		}
+		if len(o) == 0 {
+			continue
+		}
+		u := &unstructured.Unstructured{Object: o}
+		if u.IsList() {
+			l, err := u.ToList()
+			if err != nil {
+				return nil, fmt.Errorf("Unable to parse %s: %v", u.GetKind(), err)
+			}
+			for i := range l.Items {
+				ret = append(ret, &l.Items[i])
+			}
+			continue
+		}
+		if u.GetKind() == "" || u.GetName() == "" {
+			return nil, fmt.Errorf("Object without a kind and name in manifest")
+		}
+		ret = append(ret, u)
+	}
+}
+
+// resource returns the client for obj's kind and namespace. If the kind is
+// not known yet (e.g. its CRD was only just created), it refreshes the
+// discovery information for up to wait.
+func (c *Client) resource(obj *unstructured.Unstructured, wait time.Duration) (dynamic.ResourceInterface, *meta.RESTMapping, error) {
+	gvk := obj.GroupVersionKind()
+	deadline := time.Now().Add(wait)
+	mapping, err := c.mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+	for meta.IsNoMatchError(err) && time.Now().Before(deadline) {
+		time.Sleep(time.Second)
+		c.mapper.Reset()
+		mapping, err = c.mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if mapping.Scope.Name() != meta.RESTScopeNameNamespace {
+		obj.SetNamespace("")
+		return c.dynamic.Resource(mapping.Resource), mapping, nil
+	}
+	if obj.GetNamespace() == "" {
+		obj.SetNamespace(c.namespace)
+	}
+	return c.dynamic.Resource(mapping.Resource).Namespace(obj.GetNamespace()), mapping, nil
+}
+
+func result(obj *unstructured.Unstructured, action Action, err error) Result {
+	if err != nil {
+		action = Failed
+	}
+	return Result{
+		Kind:      obj.GetKind(),
+		Group:     obj.GroupVersionKind().Group,
+		Namespace: obj.GetNamespace(),
+		Name:      obj.GetName(),
+		Action:    action,
+		Err:       err,
+	}
+}
+
+// Apply creates or updates each object in contents, in order. It applies
+// every object even if some fail; see Errors.
+func (c *Client) Apply(contents []byte) ([]Result, error) {
+	objs, err := Decode(contents)
+	if err != nil {
+		return nil, err
+	}
+	results := []Result{}
+	wait := time.Duration(0)
+	for _, obj := range objs {
+		action, err := c.apply(obj, wait)
+		results = append(results, result(obj, action, err))
+		if obj.GetKind() == "CustomResourceDefinition" && err == nil {
+			// Later objects may use the new kind, which takes a moment
+			// to be served.
+			wait = 30 * time.Second
+		}
+	}
+	return results, nil
+}
+
+func (c *Client) apply(obj *unstructured.Unstructured, wait time.Duration) (Action, error) {
+	r, mapping, err := c.resource(obj, wait)
+	if err != nil {
+		return Failed, err
+	}
+	ctx := context.Background()
+
+	// Record the configuration being applied, without any previous record.
+	annotations := obj.GetAnnotations()
+	delete(annotations, LastApplied)
+	obj.SetAnnotations(annotations)
+	config, err := json.Marshal(obj.Object)
+	if err != nil {
+		return Failed, err
+	}
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	annotations[LastApplied] = string(config)
+	obj.SetAnnotations(annotations)
+
+	current, err := r.Get(ctx, obj.GetName(), metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		_, err = r.Create(ctx, obj, metav1.CreateOptions{})
+		return Created, err
+	}
+	if err != nil {
+		return Failed, err
+	}
+
+	original := []byte(current.GetAnnotations()[LastApplied])
+	modified, err := json.Marshal(obj.Object)
+	if err != nil {
+		return Failed, err
+	}
+	live, err := json.Marshal(current.Object)
+	if err != nil {
+		return Failed, err
+	}
+	var patch []byte
+	patchType := types.MergePatchType
+	if typed, err := scheme.Scheme.New(mapping.GroupVersionKind); err == nil {
+		// Built-in kinds support strategic merge, which merges lists such
+		// as containers by name rather than replacing them.
+		lookup, err := strategicpatch.NewPatchMetaFromStruct(typed)
+		if err != nil {
+			return Failed, err
+		}
+		patch, err = strategicpatch.CreateThreeWayMergePatch(original, modified, live, lookup, true)
+		if err != nil {
+			return Failed, err
+		}
+		patchType = types.StrategicMergePatchType
+	} else {
+		patch, err = jsonmergepatch.CreateThreeWayJSONMergePatch(original, modified, live)
+		if err != nil {
+			return Failed, err
+		}
+	}
+	if string(patch) == "{}" {
+		return Unchanged, nil
+	}
+	_, err = r.Patch(ctx, obj.GetName(), patchType, patch, metav1.PatchOptions{})
+	return Configured, err
+}
+
+// Delete deletes each object in contents, in reverse order, ignoring any
+// which do not exist.
+func (c *Client) Delete(contents []byte) ([]Result, error) {
+	objs, err := Decode(contents)
+	if err != nil {
+		return nil, err
+	}
+	results := []Result{}
+	for i := len(objs) - 1; i >= 0; i-- {
+		obj := objs[i]
+		r, _, err := c.resource(obj, 0)
+		if meta.IsNoMatchError(err) {
+			// The CRD was already deleted, and its objects with it.
+			results = append(results, result(obj, NotFound, nil))
+			continue
+		}
+		if err != nil {
+			results = append(results, result(obj, Failed, err))
+			continue
+		}
+		propagation := metav1.DeletePropagationBackground
+		err = r.Delete(context.Background(), obj.GetName(), metav1.DeleteOptions{PropagationPolicy: &propagation})
+		if apierrors.IsNotFound(err) {
+			results = append(results, result(obj, NotFound, nil))
+			continue
+		}
+		results = append(results, result(obj, Deleted, err))
+	}
+	return results, nil
+}
+
+// Exists reports whether any of the objects in contents exist.
+func (c *Client) Exists(contents []byte) (bool, error) {
+	objs, err := Decode(contents)
+	if err != nil {
+		return false, err
+	}
+	for _, obj := range objs {
+		r, _, err := c.resource(obj, 0)
+		if meta.IsNoMatchError(err) {
+			continue
+		}
+		if err != nil {
+			return false, err
+		}
+		_, err = r.Get(context.Background(), obj.GetName(), metav1.GetOptions{})
+		if err == nil {
+			return true, nil
+		}
+		if !apierrors.IsNotFound(err) {
+			return false, err
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,132 @@
+package apply
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/version"
+	"k8s.io/client-go/dynamic"
+)
+
+var selfSubjectAccessReviews = schema.GroupVersionResource{Group: "authorization.k8s.io", Version: "v1", Resource: "selfsubjectaccessreviews"}
+
+// mappingFor resolves a resource the way kubectl does, e.g. "configmap",
+// "deployments.apps" or "gateways.networking.istio.io".
+func (c *Client) mappingFor(resource string) (*meta.RESTMapping, error) {
+	gvr, gr := schema.ParseResourceArg(strings.ToLower(resource))
+	var gvk schema.GroupVersionKind
+	var err error
+	if gvr != nil {
+		gvk, err = c.mapper.KindFor(*gvr)
+	}
+	if gvr == nil || err != nil {
+		gvk, err = c.mapper.KindFor(gr.WithVersion(""))
+	}
+	if err != nil {
+		return nil, err
+	}
+	return c.mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+}
+
+// named returns the client for a resource, in namespace (or the kubeconfig's
+// namespace) if it is namespaced.
+func (c *Client) named(resource string, namespace string) (dynamic.ResourceInterface, error) {
+	mapping, err := c.mappingFor(resource)
+	if err != nil {
+		return nil, err
+	}
+	if mapping.Scope.Name() != meta.RESTScopeNameNamespace {
+		return c.dynamic.Resource(mapping.Resource), nil
+	}
+	if namespace == "" {
+		namespace = c.namespace
+	}
+	return c.dynamic.Resource(mapping.Resource).Namespace(namespace), nil
+}
+
+// Get returns the JSON of the named object. If name is empty, it returns a
+// list of the objects in namespace, or in all namespaces if namespace is
+// also empty. Otherwise an empty namespace is the kubeconfig's namespace.
+func (c *Client) Get(resource string, namespace string, name string) ([]byte, error) {
+	if name != "" {
+		r, err := c.named(resource, namespace)
+		if err != nil {
+			return nil, err
+		}
+		obj, err := r.Get(context.Background(), name, metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		return obj.MarshalJSON()
+	}
+	mapping, err := c.mappingFor(resource)
+	if err != nil {
+		return nil, err
+	}
+	var r dynamic.ResourceInterface = c.dynamic.Resource(mapping.Resource)
+	if namespace != "" && mapping.Scope.Name() == meta.RESTScopeNameNamespace {
+		r = c.dynamic.Resource(mapping.Resource).Namespace(namespace)
+	}
+	list, err := r.List(context.Background(), metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return list.MarshalJSON()
+}
+
+// Namespaces returns the names of the namespaces matching a label
+// selector, or of all namespaces if selector is empty.
+func (c *Client) Namespaces(selector string) ([]string, error) {
+	mapping, err := c.mappingFor("namespaces")
+	if err != nil {
+		return nil, err
+	}
+	list, err := c.dynamic.Resource(mapping.Resource).List(context.Background(), metav1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		return nil, err
+	}
+	names := []string{}
+	for _, i := range list.Items {
+		names = append(names, i.GetName())
+	}
+	return names, nil
+}
+
+// Patch applies a JSON merge patch to the named object.
+func (c *Client) Patch(resource string, namespace string, name string, patch []byte) error {
+	r, err := c.named(resource, namespace)
+	if err != nil {
+		return err
+	}
+	_, err = r.Patch(context.Background(), name, types.MergePatchType, patch, metav1.PatchOptions{})
+	return err
+}
+
+// ServerVersion returns the version of the cluster's API server.
+func (c *Client) ServerVersion() (*version.Info, error) {
+	return c.discovery.ServerVersion()
+}
+
+// IsAdmin reports whether the current identity can do anything, anywhere
+// in the cluster.
+func (c *Client) IsAdmin() (bool, error) {
+	review := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "authorization.k8s.io/v1",
+		"kind":       "SelfSubjectAccessReview",
+		"spec": map[string]interface{}{
+			"resourceAttributes": map[string]interface{}{"verb": "*", "group": "*", "resource": "*"},
+		},
+	}}
+	out, err := c.dynamic.Resource(selfSubjectAccessReviews).Create(context.Background(), review, metav1.CreateOptions{})
+	if err != nil {
+		return false, fmt.Errorf("Unable to check permissions: %v", err)
+	}
+	allowed, _, _ := unstructured.NestedBool(out.Object, "status", "allowed")
+	return allowed, nil
+}
@@ -0,0 +1,90 @@
+package apply
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+var deployments = schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"}
+
+// pollInterval is how often the Wait functions check the cluster.
+var pollInterval = 2 * time.Second
+
+// hasCondition reports whether obj has status condition t set to True.
+func hasCondition(obj *unstructured.Unstructured, t string) bool {
+	conditions, _, _ := unstructured.NestedSlice(obj.Object, "status", "conditions")
+	for _, c := range conditions {
+		if m, ok := c.(map[string]interface{}); ok && m["type"] == t && m["status"] == "True" {
+			return true
+		}
+	}
+	return false
+}
+
+// poll calls check until it returns no pending items, an error, or timeout
+// elapses.
+func poll(timeout time.Duration, what string, check func() ([]string, error)) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		pending, err := check()
+		if err != nil {
+			return err
+		}
+		if len(pending) == 0 {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			sort.Strings(pending)
+			return fmt.Errorf("Timed out after %v waiting for %s: %s", timeout, what, strings.Join(pending, ", "))
+		}
+		time.Sleep(pollInterval)
+	}
+}
+
+// WaitEstablished waits up to timeout for the named CustomResourceDefinitions
+// to be Established.
+func (c *Client) WaitEstablished(names []string, timeout time.Duration) error {
+	// Older clusters only serve apiextensions.k8s.io/v1beta1.
+	mapping, err := c.mapper.RESTMapping(schema.GroupKind{Group: "apiextensions.k8s.io", Kind: "CustomResourceDefinition"})
+	if err != nil {
+		return err
+	}
+	return poll(timeout, "CRDs to be established", func() ([]string, error) {
+		pending := []string{}
+		for _, n := range names {
+			crd, err := c.dynamic.Resource(mapping.Resource).Get(context.Background(), n, metav1.GetOptions{})
+			if err != nil {
+				return nil, err
+			}
+			if !hasCondition(crd, "Established") {
+				pending = append(pending, n)
+			}
+		}
+		return pending, nil
+	})
+}
+
+// WaitAvailable waits up to timeout for all Deployments in namespace to be
+// Available.
+func (c *Client) WaitAvailable(namespace string, timeout time.Duration) error {
+	return poll(timeout, "deployments in "+namespace+" to be available", func() ([]string, error) {
+		list, err := c.dynamic.Resource(deployments).Namespace(namespace).List(context.Background(), metav1.ListOptions{})
+		if err != nil {
+			return nil, err
+		}
+		pending := []string{}
+		for i := range list.Items {
+			if !hasCondition(&list.Items[i], "Available") {
+				pending = append(pending, list.Items[i].GetName())
+			}
+		}
+		return pending, nil
+	})
+}
@@ -10,15 +10,22 @@ import (
 
 	"github.com/AlecAivazis/survey"
 	"github.com/evankanderson/knuts/pkg"
-
-	"golang.org/x/oauth2"
-	"golang.org/x/oauth2/google"
+	"github.com/evankanderson/knuts/pkg/gcp"
 
 	iam "google.golang.org/api/iam/v1"
-	serviceusage "google.golang.org/api/serviceusage/v1"
 	"google.golang.org/api/storage/v1"
 )
 
+const (
+	// ServiceAccount is the name of the ServiceAccount which builds should
+	// run as to use the registry secrets.
+	ServiceAccount = "builder"
+	// DockerHubProvider and GCRProvider are the names of the registry
+	// secrets.
+	DockerHubProvider = "dockerhub"
+	GCRProvider       = "google-cloud-platform"
+)
+
 // ImageSecret contains the information needed to create an image push secret for build templates.
 type ImageSecret struct {
 	// Provider is a one word short description of the secret provider (used as the secret name).
@@ -59,11 +66,11 @@ func Prompt(username string) (ImageSecret, error) {
 	prompt := &survey.Password{Message: "Enter your dockerhub password: "}
 	password := ""
 	survey.AskOne(prompt, &password, nil)
-	if pkg.DryRun {
+	if pkg.Default.DryRun {
 		password = "FAKE"
 	}
 	return ImageSecret{
-		Provider: "dockerhub",
+		Provider: DockerHubProvider,
 		Hosts:    []string{"docker.io"},
 		Username: username,
 		Password: password,
@@ -74,7 +81,7 @@ func Prompt(username string) (ImageSecret, error) {
 func GCRSecret(project string) (ImageSecret, error) {
 	s, err := setupGCPSecret(project)
 	return ImageSecret{
-		Provider: "google-cloud-platform",
+		Provider: GCRProvider,
 		Hosts:    []string{"us.gcr.io", "gcr.io", "eu.gcr.io", "asia.gcr.io"},
 		Username: "X2pzb25fa2V5", // base64 encoded "_json_key"
 		Password: s,
@@ -84,19 +91,18 @@ func GCRSecret(project string) (ImageSecret, error) {
 // Secret is part of the Secret interface.
 func setupGCPSecret(project string) (string, error) {
 	ctx := context.Background()
-	creds, err := google.FindDefaultCredentials(ctx, "https://www.googleapis.com/auth/cloud-platform")
+	client, credsProject, err := gcp.Client(ctx)
 	if err != nil {
-		return "", fmt.Errorf("Failed to fetch default credentials: %v", err)
+		return "", err
 	}
-	if creds.ProjectID == "" {
-		creds.ProjectID = project
+	if credsProject == "" {
+		credsProject = project
 	}
-	fmt.Printf("Using project %q to create services and enable registry\n", creds.ProjectID)
-	client := oauth2.NewClient(ctx, creds.TokenSource)
+	fmt.Printf("Using project %q to create services and enable registry\n", credsProject)
 
-	project = "projects/" + creds.ProjectID
+	project = "projects/" + credsProject
 	// Step 1: ensure the correct services are enabled
-	err = ensureGCPServices(client, project, []string{"iam.googleapis.com", "containerregistry.googleapis.com"})
+	err = gcp.EnsureServices(client, project, []string{"iam.googleapis.com", "containerregistry.googleapis.com"})
 	if err != nil {
 		return "", err
 	}
@@ -109,7 +115,7 @@ func setupGCPSecret(project string) (string, error) {
 
 	// Step 3: Assign new service account `roles.storage.admin` to all `*.artifacts.$project.artifacts.appspot.com` buckets
 	for _, region := range []string{"artifacts", "us.artifacts", "eu.artifacts", "asia.artifacts"} {
-		err := setIamPermissions(client, creds.ProjectID, region, sa)
+		err := setIamPermissions(client, credsProject, region, sa)
 		if err != nil {
 			fmt.Printf("Failed to set permissions in %s: %v\n", region, err)
 		}
@@ -119,67 +125,12 @@ func setupGCPSecret(project string) (string, error) {
 	return getSAKey(client, sa)
 }
 
-func ensureGCPServices(client *http.Client, project string, apis []string) error {
-	smAPI, err := serviceusage.New(client)
-	if err != nil {
-		return err
-	}
-	// Check to see if we need to enable anything
-	required := map[string]bool{}
-	for _, a := range apis {
-		required[a] = true
-	}
-	token := ""
-	for len(required) > 0 {
-		list, err := smAPI.Services.List(project).Filter("state:ENABLED").PageToken(token).Do()
-		if err != nil {
-			return err // TODO: should we just try to enable blindly?
-		}
-		for _, s := range list.Services {
-			if required[s.Config.Name] {
-				delete(required, s.Config.Name)
-			}
-		}
-		token = list.NextPageToken
-		if token == "" {
-			break
-		}
-	}
-	if len(required) == 0 {
-		fmt.Printf("All services already enabled: %v\n", apis)
-		return nil
-	}
-	apis = []string{}
-	for api := range required {
-		apis = append(apis, api)
-	}
-	if pkg.DryRun {
-		fmt.Printf("Enabling APIs: %s\n", apis)
-	}
-
-	op, err := smAPI.Services.BatchEnable(
-		project,
-		&serviceusage.BatchEnableServicesRequest{ServiceIds: apis}).Do()
-	if err != nil {
-		return err
-	}
-	for !op.Done {
-		op, err = smAPI.Operations.Get(op.Name).Do()
-		if err != nil {
-			return err
-		}
-	}
-	if op.Error != nil {
-		return fmt.Errorf("Service enablement failed: %v", op.Error.Message)
-	}
-	return nil // TODO: check for op.Error
-}
-
 func createGCPServiceAccount(client *http.Client, project string) (*iam.ServiceAccount, error) {
 	iamAPI, err := iam.New(client)
 	if err != nil {
 		return nil, err
 	}
+	iamAPI.BasePath = gcp.BasePath(iamAPI.BasePath)
 	shortProject := strings.TrimPrefix(project, "projects/")
 	saName := "push-image"
 	saEmail := fmt.Sprintf("%s@%s.iam.gserviceaccount.com", saName, shortProject)
@@ -188,7 +139,7 @@ func createGCPServiceAccount(client *http.Client, project string) (*iam.ServiceA
 	if err == nil && existing.Email == saEmail {
 		return existing, nil
 	}
-	if pkg.DryRun {
+	if pkg.Default.DryRun {
 		fmt.Printf("Creating IAM account %q in %s\n", saName, project)
 		return &iam.ServiceAccount{
 			Email:    saEmail,
@@ -207,6 +158,7 @@ func setIamPermissions(client *http.Client, project string, region string, servi
 	if err != nil {
 		return err
 	}
+	storageAPI.BasePath = gcp.BasePath(storageAPI.BasePath)
 	bService := storage.NewBucketsService(storageAPI)
 	bucketName := fmt.Sprintf("%s.%s.appspot.com", region, project)
 	p, err := bService.GetIamPolicy(bucketName).Do()
@@ -240,7 +192,7 @@ func setIamPermissions(client *http.Client, project string, region string, servi
 	if newMember != "" {
 		newBinding.Members = append(newBinding.Members, newMember)
 	}
-	if pkg.DryRun {
+	if pkg.Default.DryRun {
 		fmt.Printf("Would add %s to %s\n", newMember, bucketName)
 		return nil
 	}
@@ -253,8 +205,9 @@ func getSAKey(client *http.Client, sa *iam.ServiceAccount) (string, error) {
 	if err != nil {
 		return "", err
 	}
+	iamAPI.BasePath = gcp.BasePath(iamAPI.BasePath)
 	keyService := iam.NewProjectsServiceAccountsKeysService(iamAPI)
-	if pkg.DryRun {
+	if pkg.Default.DryRun {
 		return "FAKE", nil
 	}
 	key, err := keyService.Create("projects/-/serviceAccounts/"+sa.UniqueId, &iam.CreateServiceAccountKeyRequest{}).Do()
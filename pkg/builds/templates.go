@@ -1,7 +1,11 @@
 package builds
 
 import (
-	"os"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"strings"
 
 	"github.com/evankanderson/knuts/pkg"
 )
@@ -9,6 +13,20 @@ import (
 // BuildTemplate represents the steps needed to install a particular BuildTemplate.
 type BuildTemplate pkg.Option
 
+// Template is the Data of a BuildTemplate Option, locating its manifest.
+type Template struct {
+	// Name is the Option's shortname.
+	Name string
+	URL  string
+	// Digest is the pinned SHA-256 of the manifest; see pkg.Fetch.
+	Digest string
+}
+
+// GitHubAPI is the GitHub API endpoint used by Pin.
+var GitHubAPI = "https://api.github.com"
+
+var commitRE = regexp.MustCompile("^[0-9a-f]{40}$")
+
 var (
 	// Builds contains the set of known BuildTemplates.
 	Builds = pkg.MultiSelect{
@@ -16,34 +34,78 @@ var (
 		Options: map[string]pkg.Option{
 			"jib-gradle": {
 				Description: "Gradle build with JIB",
-				Data:        "https://raw.githubusercontent.com/knative/build-templates/master/jib/jib-gradle.yaml",
+				Data:        Template{Name: "jib-gradle", URL: "https://raw.githubusercontent.com/knative/build-templates/master/jib/jib-gradle.yaml"},
 			},
 			"jib-maven": {
 				Description: "Maven build with JIB",
-				Data:        "https://raw.githubusercontent.com/knative/build-templates/master/jib/jib-maven.yaml",
+				Data:        Template{Name: "jib-maven", URL: "https://raw.githubusercontent.com/knative/build-templates/master/jib/jib-maven.yaml"},
 			},
 			"kaniko": {
 				Description: "Dockerfile with Kaniko",
-				Data:        "https://raw.githubusercontent.com/knative/build-templates/master/kaniko/kaniko.yaml",
+				Data:        Template{Name: "kaniko", URL: "https://raw.githubusercontent.com/knative/build-templates/master/kaniko/kaniko.yaml"},
 			},
 			"buildpack": {
 				Description: "Buildpack",
-				Data:        "https://raw.githubusercontent.com/knative/build-templates/master/buildpack/buildpack.yaml",
+				Data:        Template{Name: "buildpack", URL: "https://raw.githubusercontent.com/knative/build-templates/master/buildpack/buildpack.yaml"},
 			},
 			"bazel": {
 				Description: "Bazel with container_push rule",
-				Data:        "https://raw.githubusercontent.com/knative/build-templates/master/bazel/bazel.yaml",
+				Data:        Template{Name: "bazel", URL: "https://raw.githubusercontent.com/knative/build-templates/master/bazel/bazel.yaml"},
 			},
 			"buildah": {
 				Description: "Buildah mechanism for building from Dockerfiles. Requires $BUILDER_IMAGE set in your Build.",
-				Data:        "https://raw.githubusercontent.com/knative/build-templates/master/buildah/buildah.yaml",
+				Data:        Template{Name: "buildah", URL: "https://raw.githubusercontent.com/knative/build-templates/master/buildah/buildah.yaml"},
 			},
+			// TODO: pin Digests for these, as for the install catalog.
 			// TODO: buildkit is more complex, and may need additional cluster permissions.
 		},
 	}
 )
 
-// Install causes the BuildTemplate to be installed in the current kubernetes context.
-func (f BuildTemplate) Install() error {
-	return pkg.Kubectl(f.Data.(string), os.Stdout)
+// Install causes the BuildTemplate to be installed in cluster.
+func (f BuildTemplate) Install(cluster *pkg.Cluster) error {
+	t := f.Data.(Template)
+	return cluster.Kubectl(t.URL, t.Digest)
+}
+
+// Installed reports whether the BuildTemplate is present in cluster.
+func (f BuildTemplate) Installed(cluster *pkg.Cluster) (bool, error) {
+	t := f.Data.(Template)
+	return cluster.Exists(t.URL, t.Digest)
+}
+
+// Pin returns t with its URL changed to refer to the git commit which its
+// branch currently points to, and that commit. Only
+// raw.githubusercontent.com URLs can be pinned.
+func (t Template) Pin() (Template, string, error) {
+	const raw = "https://raw.githubusercontent.com/"
+	parts := strings.SplitN(strings.TrimPrefix(t.URL, raw), "/", 4)
+	if !strings.HasPrefix(t.URL, raw) || len(parts) != 4 {
+		return t, "", fmt.Errorf("%s is not a GitHub URL", t.URL)
+	}
+	owner, repo, ref, path := parts[0], parts[1], parts[2], parts[3]
+	if commitRE.MatchString(ref) {
+		return t, ref, nil
+	}
+
+	req, err := http.NewRequest("GET", fmt.Sprintf("%s/repos/%s/%s/commits/%s", GitHubAPI, owner, repo, ref), nil)
+	if err != nil {
+		return t, "", err
+	}
+	req.Header.Set("Accept", "application/vnd.github.sha")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return t, "", fmt.Errorf("Unable to look up %s/%s@%s: %v", owner, repo, ref, err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return t, "", fmt.Errorf("Unable to look up %s/%s@%s: %v", owner, repo, ref, err)
+	}
+	commit := strings.TrimSpace(string(body))
+	if resp.StatusCode != http.StatusOK || !commitRE.MatchString(commit) {
+		return t, "", fmt.Errorf("Unable to look up %s/%s@%s: %s", owner, repo, ref, resp.Status)
+	}
+	t.URL = raw + strings.Join([]string{owner, repo, commit, path}, "/")
+	return t, commit, nil
 }
@@ -0,0 +1,261 @@
+// Package bundle stores manifests on local disk, so that they can be
+// installed on clusters without internet access.
+package bundle
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// IndexFile is the name of the Index within a bundle.
+const IndexFile = "index.json"
+
+// Index records which file in a bundle holds each manifest URL.
+type Index struct {
+	Entries []Entry `json:"entries"`
+}
+
+// Entry is a single manifest in a bundle.
+type Entry struct {
+	URL string `json:"url"`
+	// File is the path of the manifest, relative to the bundle root.
+	File string `json:"file"`
+	// SHA256 is the hex digest of the file's contents.
+	SHA256 string `json:"sha256"`
+}
+
+// Bundle is an opened bundle on local disk.
+type Bundle struct {
+	root    string
+	entries map[string]Entry
+	// temp is set if root was extracted from a tarball and should be
+	// removed by Close.
+	temp bool
+}
+
+// IsTarball reports whether path names a gzipped tarball rather than a
+// directory.
+func IsTarball(path string) bool {
+	return strings.HasSuffix(path, ".tar.gz") || strings.HasSuffix(path, ".tgz")
+}
+
+// Create writes manifests, a map from URL to contents, into a new bundle at
+// path, which is a directory unless IsTarball(path).
+func Create(path string, manifests map[string][]byte) error {
+	dir := path
+	if IsTarball(path) {
+		tmp, err := ioutil.TempDir("", "knuts-bundle")
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(tmp)
+		dir = tmp
+	}
+
+	index := Index{}
+	urls := []string{}
+	for u := range manifests {
+		urls = append(urls, u)
+	}
+	sort.Strings(urls)
+	for _, u := range urls {
+		file, err := fileFor(u)
+		if err != nil {
+			return err
+		}
+		dest := filepath.Join(dir, filepath.FromSlash(file))
+		if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(dest, manifests[u], 0644); err != nil {
+			return err
+		}
+		sum := sha256.Sum256(manifests[u])
+		index.Entries = append(index.Entries, Entry{URL: u, File: file, SHA256: hex.EncodeToString(sum[:])})
+	}
+	data, err := json.MarshalIndent(index, "", "  ")
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	if err := ioutil.WriteFile(filepath.Join(dir, IndexFile), data, 0644); err != nil {
+		return err
+	}
+
+	if dir != path {
+		return writeTarball(dir, path)
+	}
+	return nil
+}
+
+// Open reads the bundle at path, which is a directory unless
+// IsTarball(path).
+func Open(path string) (*Bundle, error) {
+	b := &Bundle{root: path}
+	if IsTarball(path) {
+		tmp, err := ioutil.TempDir("", "knuts-bundle")
+		if err != nil {
+			return nil, err
+		}
+		b.root = tmp
+		b.temp = true
+		if err := extractTarball(path, tmp); err != nil {
+			b.Close()
+			return nil, err
+		}
+	}
+	data, err := ioutil.ReadFile(filepath.Join(b.root, IndexFile))
+	if err != nil {
+		b.Close()
+		return nil, fmt.Errorf("%s is not a bundle: %v", path, err)
+	}
+	index := Index{}
+	if err := json.Unmarshal(data, &index); err != nil {
+		b.Close()
+		return nil, fmt.Errorf("Unable to parse %s in %s: %v", IndexFile, path, err)
+	}
+	b.entries = map[string]Entry{}
+	for _, e := range index.Entries {
+		b.entries[e.URL] = e
+	}
+	return b, nil
+}
+
+// Read returns the manifest for url, after checking that the file holding
+// it still matches the digest recorded in the index.
+func (b *Bundle) Read(url string) ([]byte, error) {
+	e, ok := b.entries[url]
+	if !ok {
+		return nil, fmt.Errorf("%s is not in the bundle", url)
+	}
+	data, err := ioutil.ReadFile(filepath.Join(b.root, filepath.FromSlash(e.File)))
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(data)
+	if got := hex.EncodeToString(sum[:]); got != e.SHA256 {
+		return nil, fmt.Errorf("%s in the bundle has been modified: %s has sha256 %s, want %s", url, e.File, got, e.SHA256)
+	}
+	return data, nil
+}
+
+// Close removes any temporary files created by Open.
+func (b *Bundle) Close() error {
+	if b.temp {
+		return os.RemoveAll(b.root)
+	}
+	return nil
+}
+
+// fileFor returns the bundle-relative path used to store the manifest at
+// rawurl, which mirrors the URL's host and path.
+func fileFor(rawurl string) (string, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return "", fmt.Errorf("Unable to parse %q: %v", rawurl, err)
+	}
+	if u.Host == "" || strings.Contains(u.Path, "..") {
+		return "", fmt.Errorf("Unable to bundle %q: not an absolute URL", rawurl)
+	}
+	// Ports are kept, but ":" is not allowed in file names everywhere.
+	return "manifests/" + strings.Replace(u.Host, ":", "_", -1) + u.Path, nil
+}
+
+func writeTarball(dir string, dest string) error {
+	f, err := os.Create(dest)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+	err = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		hdr, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		hdr.Name = filepath.ToSlash(rel)
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		in, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer in.Close()
+		_, err = io.Copy(tw, in)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+func extractTarball(src string, dir string) error {
+	f, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return fmt.Errorf("Unable to read %s: %v", src, err)
+	}
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("Unable to read %s: %v", src, err)
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		name := filepath.Clean(filepath.FromSlash(hdr.Name))
+		if filepath.IsAbs(name) || strings.HasPrefix(name, "..") {
+			return fmt.Errorf("Unexpected path %q in %s", hdr.Name, src)
+		}
+		dest := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+			return err
+		}
+		out, err := os.Create(dest)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(out, tr); err != nil {
+			out.Close()
+			return err
+		}
+		if err := out.Close(); err != nil {
+			return err
+		}
+	}
+}
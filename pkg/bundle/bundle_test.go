@@ -0,0 +1,66 @@
+package bundle
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var manifests = map[string][]byte{
+	"https://github.com/knative/serving/releases/download/v0.3.0/serving.yaml": []byte("kind: Namespace\n"),
+	"http://registry.local:8080/templates/kaniko.yaml":                         []byte("kind: BuildTemplate\n"),
+}
+
+func TestCreateOpen(t *testing.T) {
+	for _, name := range []string{"bundle", "bundle.tar.gz"} {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), name)
+			if err := Create(path, manifests); err != nil {
+				t.Fatalf("Create() = %v", err)
+			}
+			b, err := Open(path)
+			if err != nil {
+				t.Fatalf("Open() = %v", err)
+			}
+			defer b.Close()
+			for url, want := range manifests {
+				got, err := b.Read(url)
+				if err != nil {
+					t.Fatalf("Read(%s) = %v", url, err)
+				}
+				if string(got) != string(want) {
+					t.Errorf("Read(%s) = %q, want %q", url, got, want)
+				}
+			}
+			if _, err := b.Read("https://example.dev/missing.yaml"); err == nil {
+				t.Error("Read(missing) succeeded, want an error")
+			}
+		})
+	}
+}
+
+func TestReadModified(t *testing.T) {
+	dir := t.TempDir()
+	if err := Create(dir, manifests); err != nil {
+		t.Fatalf("Create() = %v", err)
+	}
+	url := "https://github.com/knative/serving/releases/download/v0.3.0/serving.yaml"
+	file, err := fileFor(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, filepath.FromSlash(file)), []byte("kind: Secret\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	b, err := Open(dir)
+	if err != nil {
+		t.Fatalf("Open() = %v", err)
+	}
+	if _, err := b.Read(url); err == nil || !strings.Contains(err.Error(), "has been modified") {
+		t.Errorf("Read(%s) = %v, want an error about the modified file", url, err)
+	}
+	if _, err := b.Read("http://registry.local:8080/templates/kaniko.yaml"); err != nil {
+		t.Errorf("Read(unmodified) = %v", err)
+	}
+}
@@ -0,0 +1,186 @@
+// Package certs configures TLS for Knative routes, using cert-manager to
+// issue a certificate for the istio ingress gateway.
+package certs
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"text/template"
+
+	"github.com/evankanderson/knuts/pkg"
+)
+
+const (
+	// IssuerName and CertificateName are the cert-manager objects created.
+	IssuerName      = "knative-issuer"
+	CertificateName = "knative-tls"
+	// SecretName is the Secret in istio-system which the istio ingress
+	// gateway loads its certificate from.
+	SecretName = "istio-ingressgateway-certs"
+	// GatewayNamespace and GatewayName locate the Knative ingress Gateway.
+	GatewayNamespace = "knative-serving"
+	GatewayName      = "knative-ingress-gateway"
+	// LetsEncrypt is the default ACME server.
+	LetsEncrypt = "https://acme-v02.api.letsencrypt.org/directory"
+)
+
+// Kinds of Issuer.
+const (
+	SelfSigned = "selfsigned"
+	HTTP01     = "acme-http01"
+	DNS01      = "acme-dns01"
+)
+
+// Issuers lists the supported kinds of Issuer.
+var Issuers = []string{SelfSigned, HTTP01, DNS01}
+
+// Options describes the certificate to request.
+type Options struct {
+	// Issuer is one of Issuers.
+	Issuer string
+	// Email and Server are the ACME account and server.
+	Email  string
+	Server string
+	// Project and ServiceAccountSecret configure DNS01 challenges through
+	// Google Cloud DNS. The Secret must be in the cert-manager namespace,
+	// with the service account key in `key.json`.
+	Project              string
+	ServiceAccountSecret string
+	// Hosts are the DNS names for the certificate, e.g.
+	// "*.default.mycompany.dev".
+	Hosts []string
+}
+
+// Validate checks that the Options are complete for the Issuer.
+func (o Options) Validate() error {
+	if len(o.Hosts) == 0 {
+		return fmt.Errorf("No hosts to request a certificate for")
+	}
+	switch o.Issuer {
+	case SelfSigned:
+		return nil
+	case HTTP01:
+		for _, h := range o.Hosts {
+			if strings.HasPrefix(h, "*.") {
+				return fmt.Errorf("HTTP01 challenges cannot issue wildcard certificates like %s; use %s or list the hosts", h, DNS01)
+			}
+		}
+	case DNS01:
+		if o.Project == "" || o.ServiceAccountSecret == "" {
+			return fmt.Errorf("%s needs a Cloud DNS project and service account secret", DNS01)
+		}
+	default:
+		return fmt.Errorf("Unknown issuer %q, must be one of %s", o.Issuer, strings.Join(Issuers, ", "))
+	}
+	if o.Email == "" {
+		return fmt.Errorf("%s needs an email address for the ACME account", o.Issuer)
+	}
+	return nil
+}
+
+var manifest = template.Must(template.New("certs").Parse(`
+apiVersion: certmanager.k8s.io/v1alpha1
+kind: ClusterIssuer
+metadata:
+  name: {{ .IssuerName }}
+spec:
+{{- if eq .Issuer "selfsigned" }}
+  selfSigned: {}
+{{- else }}
+  acme:
+    server: {{ .Server }}
+    email: {{ .Email }}
+    privateKeySecretRef:
+      name: {{ .IssuerName }}-account-key
+{{- if eq .Issuer "acme-http01" }}
+    http01: {}
+{{- else }}
+    dns01:
+      providers:
+      - name: clouddns
+        clouddns:
+          project: {{ .Project }}
+          serviceAccountSecretRef:
+            name: {{ .ServiceAccountSecret }}
+            key: key.json
+{{- end }}
+{{- end }}
+---
+apiVersion: certmanager.k8s.io/v1alpha1
+kind: Certificate
+metadata:
+  name: {{ .CertificateName }}
+  namespace: istio-system
+spec:
+  secretName: {{ .SecretName }}
+  issuerRef:
+    name: {{ .IssuerName }}
+    kind: ClusterIssuer
+  commonName: {{ printf "%q" (index .Hosts 0) }}
+  dnsNames:{{ range .Hosts }}
+  - {{ printf "%q" . }}{{ end }}
+{{- if ne .Issuer "selfsigned" }}
+  acme:
+    config:
+    - {{ if eq .Issuer "acme-http01" }}http01:
+        ingressClass: istio{{ else }}dns01:
+        provider: clouddns{{ end }}
+      domains:{{ range .Hosts }}
+      - {{ printf "%q" . }}{{ end }}
+{{- end }}
+`))
+
+// Manifest returns the ClusterIssuer and Certificate for o.
+func Manifest(o Options) ([]byte, error) {
+	if err := o.Validate(); err != nil {
+		return nil, err
+	}
+	var b bytes.Buffer
+	err := manifest.Execute(&b, struct {
+		Options
+		IssuerName      string
+		CertificateName string
+		SecretName      string
+	}{o, IssuerName, CertificateName, SecretName})
+	return b.Bytes(), err
+}
+
+// EnableHTTPS adds an HTTPS server using the certificate to the Knative
+// ingress Gateway, if it does not already have one. In a dry run the Gateway
+// may not exist yet, since Serving has not been installed; EnableHTTPS then
+// prints the patch it would apply to it.
+func EnableHTTPS() error {
+	gw := struct {
+		Spec struct {
+			Servers []map[string]interface{} `json:"servers"`
+		} `json:"spec"`
+	}{}
+	if err := pkg.Default.GetJSON(&gw, "gateways.networking.istio.io", GatewayNamespace, GatewayName); err != nil {
+		if !pkg.Default.DryRun {
+			return err
+		}
+		fmt.Printf("Dry run: %s/%s not found (%v); the HTTPS server would be added to its existing servers\n", GatewayNamespace, GatewayName, err)
+	}
+	for _, s := range gw.Spec.Servers {
+		if port, ok := s["port"].(map[string]interface{}); ok && port["number"] == float64(443) {
+			fmt.Printf("%s already serves HTTPS\n", GatewayName)
+			return nil
+		}
+	}
+	servers := append(gw.Spec.Servers, map[string]interface{}{
+		"port":  map[string]interface{}{"number": 443, "name": "https", "protocol": "HTTPS"},
+		"hosts": []string{"*"},
+		"tls": map[string]string{
+			"mode":              "SIMPLE",
+			"privateKey":        "/etc/istio/ingressgateway-certs/tls.key",
+			"serverCertificate": "/etc/istio/ingressgateway-certs/tls.crt",
+		},
+	})
+	patch, err := json.Marshal(map[string]interface{}{"spec": map[string]interface{}{"servers": servers}})
+	if err != nil {
+		return err
+	}
+	return pkg.Default.Patch("gateways.networking.istio.io", GatewayNamespace, GatewayName, patch)
+}
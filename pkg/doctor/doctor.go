@@ -0,0 +1,233 @@
+// Package doctor runs preflight checks which catch common problems with a
+// cluster (or the machine running knuts) before anything is installed.
+package doctor
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/evankanderson/knuts/pkg"
+	"github.com/evankanderson/knuts/pkg/install"
+)
+
+// Status is the outcome of a check.
+type Status string
+
+// Statuses, from best to worst.
+const (
+	Pass Status = "pass"
+	Warn Status = "warn"
+	Fail Status = "fail"
+)
+
+// Result is the outcome of a single check.
+type Result struct {
+	Check   string `json:"check" yaml:"check"`
+	Status  Status `json:"status" yaml:"status"`
+	Message string `json:"message" yaml:"message"`
+}
+
+// Manifest is something knuts is about to install.
+type Manifest struct {
+	Name   string
+	URL    string
+	Digest string
+	// Kubernetes is the range of cluster versions it supports.
+	Kubernetes install.VersionRange
+}
+
+// Options selects the checks to run.
+type Options struct {
+	// Cluster is the cluster to check; pkg.Default if nil.
+	Cluster   *pkg.Cluster
+	Manifests []Manifest
+	// ClusterAdmin checks that the current identity can create cluster-wide
+	// objects such as CRDs.
+	ClusterAdmin bool
+	// Resources checks that the nodes can fit the Manifests' workloads.
+	Resources bool
+}
+
+// Run runs the checks selected by o. Checks which need the cluster are
+// skipped if it is unavailable.
+func Run(o Options) []Result {
+	cluster := o.Cluster
+	if cluster == nil {
+		cluster = pkg.Default
+	}
+	ok, results := versions(cluster, o.Manifests)
+	if ok {
+		if o.ClusterAdmin {
+			results = append(results, clusterAdmin(cluster))
+		}
+		if o.Resources {
+			if r, ok := resources(cluster, o.Manifests); ok {
+				results = append(results, r)
+			}
+		}
+	}
+	if len(o.Manifests) > 0 {
+		results = append(results, reachable(o.Manifests))
+	}
+	return results
+}
+
+// Failed reports whether any of results failed.
+func Failed(results []Result) bool {
+	for _, r := range results {
+		if r.Status == Fail {
+			return true
+		}
+	}
+	return false
+}
+
+type versionInfo struct {
+	Major      string `json:"major"`
+	Minor      string `json:"minor"`
+	GitVersion string `json:"gitVersion"`
+}
+
+// kubectlVersions returns the kubectl client and cluster versions. kubectl
+// still reports the client version if the cluster is unreachable.
+func kubectlVersions(cluster *pkg.Cluster) (*versionInfo, *versionInfo, error) {
+	v := struct {
+		Client *versionInfo `json:"clientVersion"`
+		Server *versionInfo `json:"serverVersion"`
+	}{}
+	var out, stderr bytes.Buffer
+	cmd := cluster.KubectlCommand("version", "--output", "json")
+	cmd.Stdout = &out
+	cmd.Stderr = &stderr
+	runErr := cmd.Run()
+	if err := json.Unmarshal(out.Bytes(), &v); err != nil || v.Client == nil {
+		return nil, nil, fmt.Errorf("Unable to get kubectl version: %v: %s", runErr, strings.TrimSpace(stderr.String()))
+	}
+	if runErr == nil {
+		return v.Client, v.Server, nil
+	}
+	if msg := strings.Join(strings.Fields(stderr.String()), " "); msg != "" {
+		return v.Client, v.Server, fmt.Errorf("%s", msg)
+	}
+	return v.Client, v.Server, runErr
+}
+
+// clusterVersion returns the cluster version, using the built-in client.
+func clusterVersion(cluster *pkg.Cluster) (*versionInfo, error) {
+	c, err := cluster.Client()
+	if err != nil {
+		return nil, err
+	}
+	v, err := c.ServerVersion()
+	if err != nil {
+		return nil, err
+	}
+	return &versionInfo{Major: v.Major, Minor: v.Minor, GitVersion: v.GitVersion}, nil
+}
+
+// versions checks the cluster version, and the kubectl version if it is
+// used, reporting whether the cluster was reachable.
+func versions(cluster *pkg.Cluster, manifests []Manifest) (bool, []Result) {
+	results := []Result{}
+	var client, server *versionInfo
+	var err error
+	if cluster.UseKubectl {
+		if err := pkg.Installed("kubectl"); err != nil {
+			return false, []Result{{Check: "kubectl", Status: Fail, Message: err.Error()}}
+		}
+		client, server, err = kubectlVersions(cluster)
+		if client == nil {
+			return false, []Result{{Check: "kubectl", Status: Fail, Message: err.Error()}}
+		}
+		results = append(results, Result{Check: "kubectl", Status: Pass, Message: "client " + client.GitVersion})
+	} else {
+		server, err = clusterVersion(cluster)
+	}
+	if server == nil {
+		return false, append(results, Result{Check: "cluster", Status: Fail, Message: fmt.Sprintf("Unable to reach the cluster: %v", err)})
+	}
+
+	major, err := strconv.Atoi(server.Major)
+	if err != nil {
+		return true, append(results, Result{Check: "cluster", Status: Warn, Message: fmt.Sprintf("Unable to parse server version %s", server.GitVersion)})
+	}
+	minor, err := strconv.Atoi(strings.TrimSuffix(server.Minor, "+"))
+	if err != nil {
+		return true, append(results, Result{Check: "cluster", Status: Warn, Message: fmt.Sprintf("Unable to parse server version %s", server.GitVersion)})
+	}
+	unsupported := []string{}
+	for _, m := range manifests {
+		if !m.Kubernetes.Contains(major, minor) {
+			unsupported = append(unsupported, fmt.Sprintf("%s needs %s", m.Name, m.Kubernetes))
+		}
+	}
+	if len(unsupported) > 0 {
+		results = append(results, Result{Check: "cluster", Status: Fail, Message: fmt.Sprintf("server %s is not supported: %s", server.GitVersion, strings.Join(unsupported, "; "))})
+	} else {
+		results = append(results, Result{Check: "cluster", Status: Pass, Message: "server " + server.GitVersion})
+	}
+
+	// kubectl supports servers one minor version older or newer.
+	if client != nil {
+		if cmajor, cminor, err := install.ParseMinorVersion(client.GitVersion); err == nil {
+			if cmajor != major || cminor < minor-1 || cminor > minor+1 {
+				results = append(results, Result{Check: "version skew", Status: Warn, Message: fmt.Sprintf("kubectl %s is not supported with server %s; use kubectl 1.%d", client.GitVersion, server.GitVersion, minor)})
+			}
+		}
+	}
+	return true, results
+}
+
+// clusterAdmin checks that the current identity can do anything, which is
+// needed to create CRDs and ClusterRoles (e.g. for Istio).
+func clusterAdmin(cluster *pkg.Cluster) Result {
+	notAdmin := Result{Check: "cluster-admin", Status: Fail, Message: "current identity is not cluster-admin, which is needed to install CRDs; on GKE, run `kubectl create clusterrolebinding cluster-admin-binding --clusterrole=cluster-admin --user=$(gcloud config get-value core/account)`"}
+	admin := Result{Check: "cluster-admin", Status: Pass, Message: "current identity has cluster-admin"}
+	if !cluster.UseKubectl {
+		c, err := cluster.Client()
+		if err != nil {
+			return Result{Check: "cluster-admin", Status: Warn, Message: err.Error()}
+		}
+		ok, err := c.IsAdmin()
+		if err != nil {
+			return Result{Check: "cluster-admin", Status: Warn, Message: err.Error()}
+		}
+		if ok {
+			return admin
+		}
+		return notAdmin
+	}
+	var out, stderr bytes.Buffer
+	cmd := cluster.KubectlCommand("auth", "can-i", "*", "*", "--all-namespaces")
+	cmd.Stdout = &out
+	cmd.Stderr = &stderr
+	// can-i exits non-zero when the answer is no.
+	cmd.Run()
+	switch strings.TrimSpace(out.String()) {
+	case "yes":
+		return admin
+	case "no":
+		return notAdmin
+	}
+	return Result{Check: "cluster-admin", Status: Warn, Message: fmt.Sprintf("Unable to check permissions: %s", strings.TrimSpace(stderr.String()))}
+}
+
+// reachable checks that every manifest can be fetched (from the network or
+// the bundle in use) and matches its pinned digest.
+func reachable(manifests []Manifest) Result {
+	failed := []string{}
+	for _, m := range manifests {
+		if _, err := pkg.Fetch(m.URL, m.Digest); err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %v", m.Name, err))
+		}
+	}
+	sort.Strings(failed)
+	if len(failed) > 0 {
+		return Result{Check: "manifests", Status: Fail, Message: strings.Join(failed, "; ")}
+	}
+	return Result{Check: "manifests", Status: Pass, Message: fmt.Sprintf("fetched %d manifests", len(manifests))}
+}
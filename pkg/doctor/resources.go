@@ -0,0 +1,230 @@
+package doctor
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/evankanderson/knuts/pkg"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// requests is an amount of CPU (in cores) and memory (in bytes).
+type requests struct {
+	CPU    float64
+	Memory float64
+}
+
+func (r *requests) add(o requests, times float64) {
+	r.CPU += o.CPU * times
+	r.Memory += o.Memory * times
+}
+
+func (r requests) String() string {
+	return fmt.Sprintf("%.1f CPUs and %.1f GiB", r.CPU, r.Memory/(1<<30))
+}
+
+// containerRequests sums the resource requests of containers.
+func containerRequests(containers []map[string]map[string]string) (requests, error) {
+	total := requests{}
+	for _, c := range containers {
+		r := requests{}
+		var err error
+		if q, ok := c["requests"]["cpu"]; ok {
+			if r.CPU, err = parseQuantity(q); err != nil {
+				return total, err
+			}
+		}
+		if q, ok := c["requests"]["memory"]; ok {
+			if r.Memory, err = parseQuantity(q); err != nil {
+				return total, err
+			}
+		}
+		total.add(r, 1)
+	}
+	return total, nil
+}
+
+// workloadRequests returns the resources requested by the Deployments,
+// StatefulSets and DaemonSets in a manifest, assuming that DaemonSets run
+// on nodes nodes.
+func workloadRequests(contents []byte, nodes int) (requests, error) {
+	total := requests{}
+	d := yaml.NewDecoder(bytes.NewReader(contents))
+	for {
+		o := struct {
+			Kind string `yaml:"kind"`
+			Spec struct {
+				Replicas *int `yaml:"replicas"`
+				Template struct {
+					Spec struct {
+						InitContainers []struct {
+							Resources map[string]map[string]string `yaml:"resources"`
+						} `yaml:"initContainers"`
+						Containers []struct {
+							Resources map[string]map[string]string `yaml:"resources"`
+						} `yaml:"containers"`
+					} `yaml:"spec"`
+				} `yaml:"template"`
+			} `yaml:"spec"`
+		}{}
+		err := d.Decode(&o)
+		if err == io.EOF {
+			return total, nil
+		}
+		if err != nil {
+			return total, fmt.Errorf("Unable to parse manifest: %v", err)
+		}
+		times := 1.0
+		switch o.Kind {
+		case "Deployment", "StatefulSet", "ReplicaSet":
+			if o.Spec.Replicas != nil {
+				times = float64(*o.Spec.Replicas)
+			}
+		case "DaemonSet":
+			times = float64(nodes)
+		default:
+			continue
+		}
+		containers := []map[string]map[string]string{}
+		for _, c := range o.Spec.Template.Spec.Containers {
+			containers = append(containers, c.Resources)
+		}
+		r, err := containerRequests(containers)
+		if err != nil {
+			return total, err
+		}
+		// Init containers run one at a time before the others, so a pod
+		// needs the most that any of them requests.
+		for _, c := range o.Spec.Template.Spec.InitContainers {
+			init, err := containerRequests([]map[string]map[string]string{c.Resources})
+			if err != nil {
+				return total, err
+			}
+			r.CPU = math.Max(r.CPU, init.CPU)
+			r.Memory = math.Max(r.Memory, init.Memory)
+		}
+		total.add(r, times)
+	}
+}
+
+// resources checks that the nodes have enough allocatable CPU and memory
+// for the workloads in manifests, on top of the pods already running. It
+// returns false if there is nothing to check.
+func resources(cluster *pkg.Cluster, manifests []Manifest) (Result, bool) {
+	nodes := struct {
+		Items []struct {
+			Spec struct {
+				Unschedulable bool `json:"unschedulable"`
+			} `json:"spec"`
+			Status struct {
+				Allocatable map[string]string `json:"allocatable"`
+			} `json:"status"`
+		} `json:"items"`
+	}{}
+	if err := cluster.GetJSON(&nodes, "nodes", "", ""); err != nil {
+		return Result{Check: "resources", Status: Warn, Message: err.Error()}, true
+	}
+	allocatable := requests{}
+	count := 0
+	for _, n := range nodes.Items {
+		if n.Spec.Unschedulable {
+			continue
+		}
+		r, err := containerRequests([]map[string]map[string]string{{"requests": n.Status.Allocatable}})
+		if err != nil {
+			return Result{Check: "resources", Status: Warn, Message: err.Error()}, true
+		}
+		allocatable.add(r, 1)
+		count++
+	}
+
+	needed := requests{}
+	for _, m := range manifests {
+		contents, err := pkg.Fetch(m.URL, m.Digest)
+		if err != nil {
+			// Reported by reachable.
+			continue
+		}
+		r, err := workloadRequests(contents, count)
+		if err != nil {
+			return Result{Check: "resources", Status: Warn, Message: fmt.Sprintf("%s: %v", m.Name, err)}, true
+		}
+		needed.add(r, 1)
+	}
+	if needed.CPU == 0 && needed.Memory == 0 {
+		return Result{}, false
+	}
+
+	pods := struct {
+		Items []struct {
+			Spec struct {
+				NodeName   string `json:"nodeName"`
+				Containers []struct {
+					Resources map[string]map[string]string `json:"resources"`
+				} `json:"containers"`
+			} `json:"spec"`
+			Status struct {
+				Phase string `json:"phase"`
+			} `json:"status"`
+		} `json:"items"`
+	}{}
+	if err := cluster.GetJSON(&pods, "pods", "", ""); err != nil {
+		return Result{Check: "resources", Status: Warn, Message: err.Error()}, true
+	}
+	free := allocatable
+	for _, p := range pods.Items {
+		if p.Spec.NodeName == "" || p.Status.Phase == "Succeeded" || p.Status.Phase == "Failed" {
+			continue
+		}
+		containers := []map[string]map[string]string{}
+		for _, c := range p.Spec.Containers {
+			containers = append(containers, c.Resources)
+		}
+		r, err := containerRequests(containers)
+		if err != nil {
+			return Result{Check: "resources", Status: Warn, Message: err.Error()}, true
+		}
+		free.add(r, -1)
+	}
+
+	message := fmt.Sprintf("needs %s; %s free of %s on %d nodes", needed, free, allocatable, count)
+	switch {
+	case needed.CPU > allocatable.CPU || needed.Memory > allocatable.Memory:
+		return Result{Check: "resources", Status: Fail, Message: message}, true
+	case needed.CPU > free.CPU || needed.Memory > free.Memory:
+		return Result{Check: "resources", Status: Warn, Message: message + " (replacing existing pods may free enough)"}, true
+	}
+	return Result{Check: "resources", Status: Pass, Message: message}, true
+}
+
+// quantitySuffixes are the multipliers of Kubernetes resource quantity
+// suffixes, longest first.
+var quantitySuffixes = []struct {
+	suffix     string
+	multiplier float64
+}{
+	{"Ki", 1 << 10}, {"Mi", 1 << 20}, {"Gi", 1 << 30}, {"Ti", 1 << 40}, {"Pi", 1 << 50}, {"Ei", 1 << 60},
+	{"n", 1e-9}, {"u", 1e-6}, {"m", 1e-3}, {"k", 1e3}, {"M", 1e6}, {"G", 1e9}, {"T", 1e12}, {"P", 1e15}, {"E", 1e18},
+}
+
+// parseQuantity parses a Kubernetes resource quantity, like "100m" or
+// "1.5Gi".
+func parseQuantity(q string) (float64, error) {
+	number := strings.TrimSpace(q)
+	multiplier := 1.0
+	for _, s := range quantitySuffixes {
+		if strings.HasSuffix(number, s.suffix) {
+			number, multiplier = strings.TrimSuffix(number, s.suffix), s.multiplier
+			break
+		}
+	}
+	v, err := strconv.ParseFloat(number, 64)
+	if err != nil {
+		return 0, fmt.Errorf("Unable to parse resource quantity %q", q)
+	}
+	return v * multiplier, nil
+}
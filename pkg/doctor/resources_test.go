@@ -0,0 +1,40 @@
+package doctor
+
+import "testing"
+
+func TestWorkloadRequests(t *testing.T) {
+	manifest := `
+kind: Deployment
+spec:
+  replicas: 2
+  template:
+    spec:
+      initContainers:
+      - resources: {requests: {cpu: 500m, memory: 1Gi}}
+      - resources: {requests: {cpu: "2"}}
+      containers:
+      - resources: {requests: {cpu: 300m, memory: 256Mi}}
+      - resources: {requests: {cpu: 200m, memory: 256Mi}}
+---
+kind: DaemonSet
+spec:
+  template:
+    spec:
+      containers:
+      - resources: {requests: {cpu: 100m, memory: 64Mi}}
+---
+kind: Service
+spec:
+  ports: [{port: 80}]
+`
+	got, err := workloadRequests([]byte(manifest), 3)
+	if err != nil {
+		t.Fatalf("workloadRequests() = %v", err)
+	}
+	// Each Deployment replica needs max(2, 0.3+0.2) CPUs and max(1Gi,
+	// 512Mi) memory; the DaemonSet runs on all 3 nodes.
+	want := requests{CPU: 2*2 + 3*0.1, Memory: 2*(1<<30) + 3*(64<<20)}
+	if got != want {
+		t.Errorf("workloadRequests() = %+v, want %+v", got, want)
+	}
+}
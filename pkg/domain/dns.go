@@ -0,0 +1,70 @@
+package domain
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/evankanderson/knuts/pkg"
+	"github.com/evankanderson/knuts/pkg/gcp"
+	dns "google.golang.org/api/dns/v1"
+)
+
+// SetWildcardRecord creates or updates a wildcard A record for domain in
+// the Cloud DNS managed zone, so that every hostname under domain resolves
+// to ip.
+func SetWildcardRecord(client *http.Client, project string, zone string, domain string, ip string, ttl int64) error {
+	api, err := dns.New(client)
+	if err != nil {
+		return err
+	}
+	api.BasePath = gcp.BasePath(api.BasePath)
+
+	z, err := api.ManagedZones.Get(project, zone).Do()
+	if err != nil {
+		return fmt.Errorf("Unable to get zone %s: %v", zone, err)
+	}
+	name := "*." + strings.TrimSuffix(domain, ".") + "."
+	if !strings.HasSuffix(name, "."+z.DnsName) {
+		return fmt.Errorf("%s is not in zone %s (%s)", name, zone, z.DnsName)
+	}
+	existing, err := api.ResourceRecordSets.List(project, zone).Name(name).Type("A").Do()
+	if err != nil {
+		return fmt.Errorf("Unable to list records in %s: %v", zone, err)
+	}
+
+	change := &dns.Change{
+		Additions: []*dns.ResourceRecordSet{{Name: name, Type: "A", Ttl: ttl, Rrdatas: []string{ip}}},
+	}
+	for _, r := range existing.Rrsets {
+		if r.Ttl == ttl && len(r.Rrdatas) == 1 && r.Rrdatas[0] == ip {
+			fmt.Printf("%s already points to %s\n", name, ip)
+			return nil
+		}
+		change.Deletions = append(change.Deletions, r)
+	}
+	fmt.Printf("Record changes in zone %s:\n", zone)
+	for _, r := range change.Deletions {
+		fmt.Printf("  - %s %s %d %s\n", r.Name, r.Type, r.Ttl, strings.Join(r.Rrdatas, ","))
+	}
+	for _, r := range change.Additions {
+		fmt.Printf("  + %s %s %d %s\n", r.Name, r.Type, r.Ttl, strings.Join(r.Rrdatas, ","))
+	}
+	if pkg.Default.DryRun {
+		fmt.Println("Dry run: not applying record changes")
+		return nil
+	}
+
+	c, err := api.Changes.Create(project, zone, change).Do()
+	if err != nil {
+		return fmt.Errorf("Unable to update records in %s: %v", zone, err)
+	}
+	for c.Status != "done" {
+		time.Sleep(time.Second)
+		if c, err = api.Changes.Get(project, zone, c.Id).Do(); err != nil {
+			return fmt.Errorf("Unable to check record changes in %s: %v", zone, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,157 @@
+package domain
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/evankanderson/knuts/pkg"
+	"github.com/evankanderson/knuts/pkg/gcp"
+	dns "google.golang.org/api/dns/v1"
+)
+
+// fakeDNS serves a Cloud DNS managed zone "z" for "example.dev." in project
+// "p", holding records, and records the changes created. The project has no
+// APIs enabled, and enabling them is recorded as a change too.
+func fakeDNS(t *testing.T, records []*dns.ResourceRecordSet) (*httptest.Server, *[]dns.Change) {
+	changes := &[]dns.Change{}
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		const zone = "/dns/v1/projects/p/managedZones/z"
+		var resp interface{}
+		switch {
+		case r.Method == "GET" && r.URL.Path == "/v1/projects/p/services":
+			resp = map[string]interface{}{"services": []interface{}{}}
+		case r.Method == "POST" && r.URL.Path == "/v1/projects/p/services:batchEnable":
+			*changes = append(*changes, dns.Change{Id: "batchEnable"})
+			resp = map[string]interface{}{"name": "operations/1", "done": true}
+		case r.Method == "GET" && r.URL.Path == zone:
+			resp = dns.ManagedZone{Name: "z", DnsName: "example.dev."}
+		case r.Method == "GET" && r.URL.Path == zone+"/rrsets":
+			matching := []*dns.ResourceRecordSet{}
+			for _, rr := range records {
+				if rr.Name == r.URL.Query().Get("name") && rr.Type == r.URL.Query().Get("type") {
+					matching = append(matching, rr)
+				}
+			}
+			resp = dns.ResourceRecordSetsListResponse{Rrsets: matching}
+		case r.Method == "POST" && r.URL.Path == zone+"/changes":
+			c := dns.Change{}
+			if err := json.NewDecoder(r.Body).Decode(&c); err != nil {
+				t.Errorf("Invalid change: %v", err)
+			}
+			*changes = append(*changes, c)
+			c.Id, c.Status = "1", "done"
+			resp = c
+		default:
+			t.Errorf("Unexpected request %s %s", r.Method, r.URL)
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(resp)
+	}))
+	return s, changes
+}
+
+func TestSetWildcardRecord(t *testing.T) {
+	record := func(ip string, ttl int64) *dns.ResourceRecordSet {
+		return &dns.ResourceRecordSet{Name: "*.knative.example.dev.", Type: "A", Ttl: ttl, Rrdatas: []string{ip}}
+	}
+	tests := []struct {
+		name     string
+		existing []*dns.ResourceRecordSet
+		dryRun   bool
+		want     []dns.Change
+	}{{
+		name: "create",
+		want: []dns.Change{{Additions: []*dns.ResourceRecordSet{record("35.1.2.3", 300)}}},
+	}, {
+		name:     "unchanged",
+		existing: []*dns.ResourceRecordSet{record("35.1.2.3", 300)},
+		want:     []dns.Change{},
+	}, {
+		name:     "replace",
+		existing: []*dns.ResourceRecordSet{record("35.9.9.9", 300)},
+		want: []dns.Change{{
+			Additions: []*dns.ResourceRecordSet{record("35.1.2.3", 300)},
+			Deletions: []*dns.ResourceRecordSet{record("35.9.9.9", 300)},
+		}},
+	}, {
+		name:     "replace ttl",
+		existing: []*dns.ResourceRecordSet{record("35.1.2.3", 60)},
+		want: []dns.Change{{
+			Additions: []*dns.ResourceRecordSet{record("35.1.2.3", 300)},
+			Deletions: []*dns.ResourceRecordSet{record("35.1.2.3", 60)},
+		}},
+	}, {
+		name:     "dry run",
+		existing: []*dns.ResourceRecordSet{record("35.9.9.9", 300)},
+		dryRun:   true,
+		want:     []dns.Change{},
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, changes := fakeDNS(t, tt.existing)
+			defer s.Close()
+			defer func(endpoint string, dryRun bool) {
+				gcp.Endpoint, pkg.Default.DryRun = endpoint, dryRun
+			}(gcp.Endpoint, pkg.Default.DryRun)
+			gcp.Endpoint, pkg.Default.DryRun = s.URL, tt.dryRun
+
+			if err := SetWildcardRecord(s.Client(), "p", "z", "knative.example.dev", "35.1.2.3", 300); err != nil {
+				t.Fatalf("SetWildcardRecord() = %v", err)
+			}
+			if !reflect.DeepEqual(*changes, tt.want) {
+				got, _ := json.Marshal(*changes)
+				want, _ := json.Marshal(tt.want)
+				t.Errorf("SetWildcardRecord() made changes %s, want %s", got, want)
+			}
+		})
+	}
+}
+
+func TestSetWildcardRecordOutsideZone(t *testing.T) {
+	s, changes := fakeDNS(t, nil)
+	defer s.Close()
+	defer func(endpoint string) { gcp.Endpoint = endpoint }(gcp.Endpoint)
+	gcp.Endpoint = s.URL
+
+	err := SetWildcardRecord(s.Client(), "p", "z", "knative.example.org", "35.1.2.3", 300)
+	if err == nil || !strings.Contains(err.Error(), "is not in zone z") {
+		t.Errorf("SetWildcardRecord() = %v, want an error about the zone", err)
+	}
+	if len(*changes) != 0 {
+		t.Errorf("SetWildcardRecord() made changes %v, want none", *changes)
+	}
+}
+
+func TestDNSDryRun(t *testing.T) {
+	s, changes := fakeDNS(t, nil)
+	defer s.Close()
+	defer func(endpoint string, dryRun bool) {
+		gcp.Endpoint, pkg.Default.DryRun = endpoint, dryRun
+	}(gcp.Endpoint, pkg.Default.DryRun)
+	gcp.Endpoint, pkg.Default.DryRun = s.URL, true
+
+	// As `knuts domain dns` does.
+	if err := gcp.EnsureServices(s.Client(), "projects/p", []string{"dns.googleapis.com"}); err != nil {
+		t.Fatalf("EnsureServices() = %v", err)
+	}
+	if err := SetWildcardRecord(s.Client(), "p", "z", "knative.example.dev", "35.1.2.3", 300); err != nil {
+		t.Fatalf("SetWildcardRecord() = %v", err)
+	}
+	if len(*changes) != 0 {
+		got, _ := json.Marshal(*changes)
+		t.Errorf("Dry run made changes %s, want none", got)
+	}
+
+	pkg.Default.DryRun = false
+	if err := gcp.EnsureServices(s.Client(), "projects/p", []string{"dns.googleapis.com"}); err != nil {
+		t.Fatalf("EnsureServices() = %v", err)
+	}
+	if len(*changes) != 1 || (*changes)[0].Id != "batchEnable" {
+		t.Errorf("EnsureServices() made changes %v, want batchEnable", *changes)
+	}
+}
@@ -0,0 +1,172 @@
+// Package domain manages the domains Knative Serving assigns to Routes,
+// which are configured by the config-domain ConfigMap.
+package domain
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/evankanderson/knuts/pkg"
+	yaml "gopkg.in/yaml.v2"
+)
+
+const (
+	// Namespace and ConfigMap locate the domain configuration.
+	Namespace = "knative-serving"
+	ConfigMap = "config-domain"
+	// DefaultDomain is used by Knative if no domain is configured.
+	DefaultDomain = "example.com"
+)
+
+// Config maps each domain to the label selector of the Routes which use
+// it. The default domain has an empty selector.
+type Config map[string]map[string]string
+
+type selectorSpec struct {
+	Selector map[string]string `yaml:"selector"`
+}
+
+// Parse reads a Config from the data of the config-domain ConfigMap.
+func Parse(data map[string]string) (Config, error) {
+	c := Config{}
+	for d, v := range data {
+		if strings.HasPrefix(d, "_") {
+			// e.g. _example, which documents the format.
+			continue
+		}
+		s := selectorSpec{}
+		if err := yaml.Unmarshal([]byte(v), &s); err != nil {
+			return nil, fmt.Errorf("Unable to parse selector for %s: %v", d, err)
+		}
+		c[d] = s.Selector
+	}
+	return c, nil
+}
+
+// Get reads the Config from the cluster.
+func Get() (Config, error) {
+	cm := struct {
+		Data map[string]string `json:"data"`
+	}{}
+	if err := pkg.Default.GetJSON(&cm, "configmap", Namespace, ConfigMap); err != nil {
+		return nil, err
+	}
+	return Parse(cm.Data)
+}
+
+// Set makes domain the default domain if selector is empty, or otherwise
+// the domain for Routes matching selector, replacing any other domain with
+// the same selector. It returns the changes as a merge patch for the
+// ConfigMap's data, where nil values remove keys.
+func (c Config) Set(domain string, selector map[string]string) map[string]interface{} {
+	changes := map[string]interface{}{}
+	for d, s := range c {
+		if d != domain && len(s) == len(selector) && matches(s, selector) {
+			delete(c, d)
+			changes[d] = nil
+		}
+	}
+	c[domain] = selector
+	changes[domain] = ""
+	if len(selector) > 0 {
+		b, _ := yaml.Marshal(selectorSpec{Selector: selector})
+		changes[domain] = string(b)
+	}
+	return changes
+}
+
+// Default returns the domain used for Routes which match no selector.
+func (c Config) Default() string {
+	return c.DomainFor(nil)
+}
+
+// DomainFor returns the domain Knative assigns to a Route with labels: the
+// domain with the most specific matching selector, or the default domain.
+func (c Config) DomainFor(labels map[string]string) string {
+	domains := []string{}
+	for d := range c {
+		domains = append(domains, d)
+	}
+	sort.Strings(domains)
+	best, size := "", -1
+	for _, d := range domains {
+		if len(c[d]) > size && matches(c[d], labels) {
+			best, size = d, len(c[d])
+		}
+	}
+	if best == "" {
+		return DefaultDomain
+	}
+	return best
+}
+
+func matches(selector map[string]string, labels map[string]string) bool {
+	for k, v := range selector {
+		if labels[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// Host returns the hostname of the Knative Service name in namespace under
+// domain.
+func Host(name string, namespace string, domain string) string {
+	return fmt.Sprintf("%s.%s.%s", name, namespace, domain)
+}
+
+// Service is a Knative Service, which has a Route of the same name.
+type Service struct {
+	Name      string
+	Namespace string
+	Labels    map[string]string
+}
+
+// Services lists the Knative Services in all namespaces.
+func Services() ([]Service, error) {
+	list := struct {
+		Items []struct {
+			Metadata struct {
+				Name      string            `json:"name"`
+				Namespace string            `json:"namespace"`
+				Labels    map[string]string `json:"labels"`
+			} `json:"metadata"`
+		} `json:"items"`
+	}{}
+	if err := pkg.Default.GetJSON(&list, "services.serving.knative.dev", "", ""); err != nil {
+		return nil, err
+	}
+	ret := []Service{}
+	for _, i := range list.Items {
+		ret = append(ret, Service{Name: i.Metadata.Name, Namespace: i.Metadata.Namespace, Labels: i.Metadata.Labels})
+	}
+	return ret, nil
+}
+
+// IngressAddress returns the external IP address (or hostname) of the
+// ingress gateway Service namespace/name.
+func IngressAddress(namespace string, name string) (string, error) {
+	svc := struct {
+		Status struct {
+			LoadBalancer struct {
+				Ingress []struct {
+					IP       string `json:"ip"`
+					Hostname string `json:"hostname"`
+				} `json:"ingress"`
+			} `json:"loadBalancer"`
+		} `json:"status"`
+	}{}
+	if err := pkg.Default.GetJSON(&svc, "service", namespace, name); err != nil {
+		return "", err
+	}
+	for _, i := range svc.Status.LoadBalancer.Ingress {
+		if i.IP != "" {
+			return i.IP, nil
+		}
+		if i.Hostname != "" {
+			return i.Hostname, nil
+		}
+	}
+	return "", fmt.Errorf("%s has no external address yet", name)
+}
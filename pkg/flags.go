@@ -2,14 +2,18 @@ package pkg
 
 import (
 	"fmt"
+	"os"
 	"sort"
 	"strings"
 
 	"github.com/AlecAivazis/survey"
+	isatty "github.com/mattn/go-isatty"
 )
 
-// DryRun is a global flag indicating that no write actions should be taken.
-var DryRun = true
+// Interactive reports whether stdin is a terminal which can answer prompts.
+func Interactive() bool {
+	return isatty.IsTerminal(os.Stdin.Fd())
+}
 
 // MultiSelect is a flags.Value implementing interface which selects one or
 // more items from a list of Options, and will prompt if no items are
@@ -28,6 +32,17 @@ type Option struct {
 	Data        interface{}
 }
 
+// Select is a flags.Value implementing interface which selects exactly one
+// item from a list of Options, and will prompt if no item is selected by
+// the flag.
+type Select struct {
+	// Options provides a mapping from shortname to a description and a selected object.
+	Options  map[string]Option
+	selected string
+	// Description is a string describing what the Select covers.
+	Description string
+}
+
 // Prompt is a flags.Value implementing interface for a string which will
 // interactively prompt if the value is not provided.
 type Prompt struct {
@@ -55,7 +70,7 @@ func (m *MultiSelect) String() string {
 func (m *MultiSelect) Set(value string) error {
 	m.init()
 	for _, i := range strings.Split(value, ",") {
-		if _, ok := m.Options[i]; !ok {
+		if _, ok := m.Options[i]; !ok && m.Options != nil {
 			return fmt.Errorf("Unable to recognize %q", i)
 		}
 		m.selected[i] = true
@@ -63,6 +78,20 @@ func (m *MultiSelect) Set(value string) error {
 	return nil
 }
 
+// SetOptions replaces the available Options, for flags whose choices are
+// not known until after flag parsing. Any values already selected must be
+// present in the new Options.
+func (m *MultiSelect) SetOptions(options map[string]Option) error {
+	m.init()
+	for k := range m.selected {
+		if _, ok := options[k]; !ok {
+			return fmt.Errorf("Unable to recognize %q", k)
+		}
+	}
+	m.Options = options
+	return nil
+}
+
 // Type implements the pflag.Value interface.
 func (m *MultiSelect) Type() string {
 	return "multiSelect"
@@ -107,6 +136,72 @@ func (m *MultiSelect) prompt() error {
 	return nil
 }
 
+// String implements the flag.Value interface.
+func (s *Select) String() string {
+	return s.selected
+}
+
+// Set implements the flag.Value interface.
+func (s *Select) Set(value string) error {
+	if _, ok := s.Options[value]; !ok && s.Options != nil {
+		return fmt.Errorf("Unable to recognize %q", value)
+	}
+	s.selected = value
+	return nil
+}
+
+// SetOptions replaces the available Options, for flags whose choices are
+// not known until after flag parsing. Any value already selected must be
+// present in the new Options.
+func (s *Select) SetOptions(options map[string]Option) error {
+	if _, ok := options[s.selected]; !ok && s.selected != "" {
+		return fmt.Errorf("Unable to recognize %q", s.selected)
+	}
+	s.Options = options
+	return nil
+}
+
+// Type implements the pflag.Value interface.
+func (s *Select) Type() string {
+	return "select"
+}
+
+// Get implements the flag.Getter interface.
+func (s *Select) Get() interface{} {
+	if s.selected == "" {
+		if err := s.prompt(); err != nil {
+			fmt.Printf("Error prompting for %s: %v", s.Description, err)
+			return Option{}
+		}
+	}
+	return s.Options[s.selected]
+}
+
+// Selected returns the shortname of the selected Option, prompting if
+// needed.
+func (s *Select) Selected() string {
+	s.Get()
+	return s.selected
+}
+
+func (s *Select) prompt() error {
+	choices := []string{}
+	for k, v := range s.Options {
+		choices = append(choices, fmt.Sprintf("%s: %s", k, v.Description))
+	}
+	sort.Strings(choices)
+	question := &survey.Select{
+		Message: s.Description,
+		Options: choices,
+	}
+	answer := ""
+	if err := survey.AskOne(question, &answer, nil); err != nil {
+		return err
+	}
+	s.selected = strings.SplitN(answer, ":", 2)[0]
+	return nil
+}
+
 // String implements the flag.Value interface.
 func (p *Prompt) String() string {
 	return p.data
@@ -0,0 +1,140 @@
+// Package fleet supports acting on several clusters at once: reading fleet
+// files which list them, running a function for each in parallel, and
+// keeping their output apart.
+package fleet
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sync"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Member is a cluster in a fleet, identified by its kubeconfig context.
+type Member struct {
+	Context string `yaml:"context"`
+	// Kubeconfig is the kubeconfig file holding Context, if it is not in the
+	// default one.
+	Kubeconfig string `yaml:"kubeconfig,omitempty"`
+}
+
+// Fleet is the contents of a fleet file.
+type Fleet struct {
+	Clusters []Member `yaml:"clusters"`
+}
+
+// Read reads the fleet file at path.
+func Read(path string) (*Fleet, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	f := &Fleet{}
+	if err := yaml.UnmarshalStrict(data, f); err != nil {
+		return nil, fmt.Errorf("Unable to parse fleet %s: %v", path, err)
+	}
+	if err := f.validate(); err != nil {
+		return nil, fmt.Errorf("Fleet %s %v", path, err)
+	}
+	return f, nil
+}
+
+// Contexts returns a Fleet of the named contexts, in the same kubeconfig.
+func Contexts(names []string) (*Fleet, error) {
+	f := &Fleet{}
+	for _, n := range names {
+		f.Clusters = append(f.Clusters, Member{Context: n})
+	}
+	if err := f.validate(); err != nil {
+		return nil, fmt.Errorf("--contexts %v", err)
+	}
+	return f, nil
+}
+
+func (f *Fleet) validate() error {
+	if len(f.Clusters) == 0 {
+		return fmt.Errorf("has no clusters")
+	}
+	seen := map[string]bool{}
+	for _, m := range f.Clusters {
+		if m.Context == "" {
+			return fmt.Errorf("has a cluster with no context")
+		}
+		if seen[m.Context] {
+			return fmt.Errorf("lists context %q more than once", m.Context)
+		}
+		seen[m.Context] = true
+	}
+	return nil
+}
+
+// Run calls fn for each of n clusters, running at most concurrency at
+// once, and returns the error from each.
+func Run(n int, concurrency int, fn func(i int) error) []error {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	errs := make([]error, n)
+	slots := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		slots <- struct{}{}
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-slots }()
+			errs[i] = fn(i)
+		}(i)
+	}
+	wg.Wait()
+	return errs
+}
+
+// Writer prefixes each line written to it, and writes only whole lines to
+// the underlying writer (see Flush), so that the output of several
+// Writers sharing a mutex is not interleaved within lines.
+type Writer struct {
+	mu     *sync.Mutex
+	w      io.Writer
+	prefix string
+	buf    []byte
+}
+
+// NewWriter returns a Writer which writes lines to w with prefix, holding
+// mu while it does so.
+func NewWriter(w io.Writer, mu *sync.Mutex, prefix string) *Writer {
+	return &Writer{mu: mu, w: w, prefix: prefix}
+}
+
+// Write implements io.Writer.
+func (p *Writer) Write(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.buf = append(p.buf, b...)
+	for {
+		i := bytes.IndexByte(p.buf, '\n')
+		if i < 0 {
+			return len(b), nil
+		}
+		line := p.buf[:i+1]
+		p.buf = p.buf[i+1:]
+		if _, err := fmt.Fprintf(p.w, "%s%s", p.prefix, line); err != nil {
+			return len(b), err
+		}
+	}
+}
+
+// Flush writes any final line which was not terminated by a newline.
+func (p *Writer) Flush() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.buf) == 0 {
+		return nil
+	}
+	_, err := fmt.Fprintf(p.w, "%s%s\n", p.prefix, p.buf)
+	p.buf = nil
+	return err
+}
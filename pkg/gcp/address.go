@@ -0,0 +1,57 @@
+package gcp
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/evankanderson/knuts/pkg"
+	compute "google.golang.org/api/compute/v1"
+	"google.golang.org/api/googleapi"
+)
+
+// ReserveAddress returns the regional static IP address called name in
+// project, reserving it first if it does not exist. In a dry run, a
+// placeholder is returned for a new address.
+func ReserveAddress(client *http.Client, project string, region string, name string) (string, error) {
+	api, err := compute.New(client)
+	if err != nil {
+		return "", err
+	}
+	api.BasePath = BasePath(api.BasePath)
+
+	a, err := api.Addresses.Get(project, region, name).Do()
+	if err == nil {
+		fmt.Printf("Using static IP %s (%s) in %s\n", a.Address, name, region)
+		return a.Address, nil
+	}
+	if e, ok := err.(*googleapi.Error); !ok || e.Code != http.StatusNotFound {
+		return "", fmt.Errorf("Unable to get address %s: %v", name, err)
+	}
+	if pkg.Default.DryRun {
+		fmt.Printf("Dry run: reserving static IP %s in %s\n", name, region)
+		return fmt.Sprintf("<static IP %s>", name), nil
+	}
+
+	op, err := api.Addresses.Insert(project, region, &compute.Address{
+		Name:        name,
+		Description: "Knative ingress gateway",
+	}).Do()
+	if err != nil {
+		return "", fmt.Errorf("Unable to reserve address %s: %v", name, err)
+	}
+	for op.Status != "DONE" {
+		time.Sleep(time.Second)
+		if op, err = api.RegionOperations.Get(project, region, op.Name).Do(); err != nil {
+			return "", fmt.Errorf("Unable to check reservation of %s: %v", name, err)
+		}
+	}
+	if op.Error != nil && len(op.Error.Errors) > 0 {
+		return "", fmt.Errorf("Unable to reserve address %s: %s", name, op.Error.Errors[0].Message)
+	}
+	if a, err = api.Addresses.Get(project, region, name).Do(); err != nil {
+		return "", fmt.Errorf("Unable to get address %s: %v", name, err)
+	}
+	fmt.Printf("Reserved static IP %s (%s) in %s\n", a.Address, name, region)
+	return a.Address, nil
+}
@@ -0,0 +1,114 @@
+// Package gcp holds helpers shared by the commands which call Google Cloud
+// APIs.
+package gcp
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+
+	"github.com/evankanderson/knuts/pkg"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+	serviceusage "google.golang.org/api/serviceusage/v1"
+)
+
+// Endpoint, if set, replaces the scheme and host of every Google API call,
+// e.g. to use a local fake API server for testing. Default credentials are
+// not used with a custom Endpoint.
+var Endpoint = ""
+
+// Client returns an HTTP client authorized with the default Google
+// credentials, and the project those credentials belong to (which may be
+// empty).
+func Client(ctx context.Context) (*http.Client, string, error) {
+	if Endpoint != "" {
+		return http.DefaultClient, "", nil
+	}
+	creds, err := google.FindDefaultCredentials(ctx, "https://www.googleapis.com/auth/cloud-platform")
+	if err != nil {
+		return nil, "", fmt.Errorf("Failed to fetch default credentials: %v", err)
+	}
+	return oauth2.NewClient(ctx, creds.TokenSource), creds.ProjectID, nil
+}
+
+// BasePath returns the BasePath to use for an API whose default is
+// basePath, taking Endpoint into account.
+func BasePath(basePath string) string {
+	if Endpoint == "" {
+		return basePath
+	}
+	u, err := url.Parse(basePath)
+	if err != nil {
+		return basePath
+	}
+	e, err := url.Parse(Endpoint)
+	if err != nil {
+		return basePath
+	}
+	u.Scheme, u.Host = e.Scheme, e.Host
+	return u.String()
+}
+
+// EnsureServices enables any of apis which are not already enabled in
+// project, which is of the form "projects/NAME".
+func EnsureServices(client *http.Client, project string, apis []string) error {
+	smAPI, err := serviceusage.New(client)
+	if err != nil {
+		return err
+	}
+	smAPI.BasePath = BasePath(smAPI.BasePath)
+	// Check to see if we need to enable anything
+	required := map[string]bool{}
+	for _, a := range apis {
+		required[a] = true
+	}
+	token := ""
+	for len(required) > 0 {
+		list, err := smAPI.Services.List(project).Filter("state:ENABLED").PageToken(token).Do()
+		if err != nil {
+			return err // TODO: should we just try to enable blindly?
+		}
+		for _, s := range list.Services {
+			if required[s.Config.Name] {
+				delete(required, s.Config.Name)
+			}
+		}
+		token = list.NextPageToken
+		if token == "" {
+			break
+		}
+	}
+	if len(required) == 0 {
+		fmt.Printf("All services already enabled: %v\n", apis)
+		return nil
+	}
+	apis = []string{}
+	for api := range required {
+		apis = append(apis, api)
+	}
+	sort.Strings(apis)
+	if pkg.Default.DryRun {
+		fmt.Printf("Enabling APIs: %s\n", apis)
+		return nil
+	}
+
+	op, err := smAPI.Services.BatchEnable(
+		project,
+		&serviceusage.BatchEnableServicesRequest{ServiceIds: apis}).Do()
+	if err != nil {
+		return err
+	}
+	for !op.Done {
+		op, err = smAPI.Operations.Get(op.Name).Do()
+		if err != nil {
+			return err
+		}
+	}
+	if op.Error != nil {
+		return fmt.Errorf("Service enablement failed: %v", op.Error.Message)
+	}
+	return nil
+}
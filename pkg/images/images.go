@@ -0,0 +1,215 @@
+// Package images finds and rewrites the container images referenced by
+// kubernetes manifests.
+package images
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Ref is a reference to a container image in a manifest.
+type Ref struct {
+	// Image is the reference as written in the manifest.
+	Image string
+	// Object identifies where the reference was found, e.g.
+	// "Deployment knative-serving/controller".
+	Object string
+	// Path is the location of the reference within Object, e.g.
+	// "spec.template.spec.containers[controller].image".
+	Path string
+}
+
+// pinned matches image references with a registry host and a digest, which
+// are recognized wherever they appear (e.g. in container args).
+var pinned = regexp.MustCompile(`^[a-zA-Z0-9-]+(\.[a-zA-Z0-9-]+)+(:[0-9]+)?/[a-z0-9._/-]+(:[a-zA-Z0-9_][a-zA-Z0-9_.-]*)?@sha256:[0-9a-f]{64}$`)
+
+// Find lists the image references in a multi-document yaml manifest. These
+// are the values of `image` fields (in pod specs and BuildTemplate steps),
+// defaults of BuildTemplate parameters named `*_IMAGE`, ConfigMap keys
+// containing "image", and any other string (such as a container argument)
+// which is a registry image pinned by digest.
+func Find(contents []byte) ([]Ref, error) {
+	refs := []Ref{}
+	_, err := rewrite(contents, func(r Ref) string {
+		refs = append(refs, r)
+		return r.Image
+	})
+	return refs, err
+}
+
+// Relocate rewrites every image reference found by Find to be pulled from
+// registry instead, as described by Destination. It returns the rewritten
+// manifest and a map from each original reference to its destination.
+func Relocate(contents []byte, registry string) ([]byte, map[string]string, error) {
+	mapping := map[string]string{}
+	out, err := rewrite(contents, func(r Ref) string {
+		mapping[r.Image] = Destination(r.Image, registry)
+		return mapping[r.Image]
+	})
+	if err != nil || len(mapping) == 0 {
+		return contents, mapping, err
+	}
+	return out, mapping, nil
+}
+
+// Destination returns the reference under registry for image, keeping its
+// repository path (without the source registry host), tag and digest. For
+// example, "gcr.io/knative-releases/foo@sha256:..." relocated to
+// "registry.internal/knative" becomes
+// "registry.internal/knative/knative-releases/foo@sha256:...".
+func Destination(image string, registry string) string {
+	_, path := Split(image)
+	return strings.TrimSuffix(registry, "/") + "/" + path
+}
+
+// Split divides image into its registry host and the rest of the
+// reference, applying the Docker Hub defaults: "busybox" is
+// ("docker.io", "library/busybox").
+func Split(image string) (string, string) {
+	parts := strings.SplitN(image, "/", 2)
+	if len(parts) == 2 && (strings.ContainsAny(parts[0], ".:") || parts[0] == "localhost") {
+		return parts[0], parts[1]
+	}
+	if len(parts) == 1 {
+		return "docker.io", "library/" + image
+	}
+	return "docker.io", image
+}
+
+// Digest returns the "sha256:..." digest image is pinned to, if any.
+func Digest(image string) string {
+	if i := strings.Index(image, "@"); i >= 0 {
+		return image[i+1:]
+	}
+	return ""
+}
+
+// rewrite calls f for each image reference in contents, and returns the
+// manifest with each reference replaced by the result.
+func rewrite(contents []byte, f func(Ref) string) ([]byte, error) {
+	out := bytes.Buffer{}
+	d := yaml.NewDecoder(bytes.NewReader(contents))
+	for {
+		doc := yaml.MapSlice{}
+		err := d.Decode(&doc)
+		if err == io.EOF {
+			return out.Bytes(), nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Unable to parse manifest: %v", err)
+		}
+		if len(doc) == 0 {
+			continue
+		}
+		w := walker{kind: str(lookup(doc, "kind")), f: f}
+		meta, _ := lookup(doc, "metadata").(yaml.MapSlice)
+		w.object = w.kind + " " + str(lookup(meta, "name"))
+		if ns := str(lookup(meta, "namespace")); ns != "" {
+			w.object = w.kind + " " + ns + "/" + str(lookup(meta, "name"))
+		}
+		w.walk(doc, "")
+
+		b, err := yaml.Marshal(doc)
+		if err != nil {
+			return nil, err
+		}
+		out.WriteString("---\n")
+		out.Write(b)
+	}
+}
+
+type walker struct {
+	kind   string
+	object string
+	f      func(Ref) string
+}
+
+// walk visits node, which is at path within the object, and returns the
+// (possibly rewritten) node.
+func (w walker) walk(node interface{}, path string) interface{} {
+	switch n := node.(type) {
+	case yaml.MapSlice:
+		for i, item := range n {
+			key := str(item.Key)
+			p := join(path, key)
+			s, isString := item.Value.(string)
+			switch {
+			case isString && w.isImage(path, key, s, n):
+				n[i].Value = w.visit(s, p)
+			default:
+				n[i].Value = w.walk(item.Value, p)
+			}
+		}
+		return n
+	case []interface{}:
+		for i, item := range n {
+			// Name list items where possible, e.g. containers[controller].
+			label := fmt.Sprint(i)
+			if m, ok := item.(yaml.MapSlice); ok && str(lookup(m, "name")) != "" {
+				label = str(lookup(m, "name"))
+			}
+			n[i] = w.walk(item, fmt.Sprintf("%s[%s]", path, label))
+		}
+		return n
+	case string:
+		if pinned.MatchString(n) {
+			return w.visit(n, path)
+		}
+		// e.g. "--queue-sidecar-image=gcr.io/..."
+		if i := strings.Index(n, "="); i >= 0 && pinned.MatchString(n[i+1:]) {
+			return n[:i+1] + w.visit(n[i+1:], path)
+		}
+	}
+	return node
+}
+
+// isImage reports whether the string value s of key in the map m (at path)
+// is an image reference.
+func (w walker) isImage(path string, key string, s string, m yaml.MapSlice) bool {
+	if s == "" || strings.Contains(s, "$") || strings.ContainsAny(s, " \n") {
+		// Empty, or a BuildTemplate parameter substitution like
+		// ${BUILDER_IMAGE}, or not an image at all.
+		return false
+	}
+	switch {
+	case key == "image" || pinned.MatchString(s):
+		return true
+	case w.kind == "ConfigMap" && path == "data":
+		return strings.Contains(strings.ToLower(key), "image")
+	case w.kind == "BuildTemplate" && key == "default" && strings.HasPrefix(path, "spec.parameters"):
+		return strings.HasSuffix(str(lookup(m, "name")), "_IMAGE")
+	}
+	return false
+}
+
+func (w walker) visit(image string, path string) string {
+	return w.f(Ref{Image: image, Object: w.object, Path: path})
+}
+
+func lookup(m yaml.MapSlice, key string) interface{} {
+	for _, item := range m {
+		if str(item.Key) == key {
+			return item.Value
+		}
+	}
+	return nil
+}
+
+func str(v interface{}) string {
+	if v == nil {
+		return ""
+	}
+	return fmt.Sprint(v)
+}
+
+func join(path string, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
@@ -0,0 +1,272 @@
+package images
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+const digest = "sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+
+var manifest = `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: controller
+  namespace: knative-serving
+spec:
+  template:
+    spec:
+      containers:
+      - name: controller
+        image: gcr.io/knative-releases/github.com/knative/serving/cmd/controller@` + digest + `
+        args:
+        - -queueSidecarImage
+        - gcr.io/knative-releases/github.com/knative/serving/cmd/queue@` + digest + `
+        - --logging-image=gcr.io/knative-releases/github.com/knative/serving/cmd/logging@` + digest + `
+        - --not-pinned=gcr.io/knative-releases/cmd/other:latest
+        - --level=debug
+        env:
+        - name: MIRROR
+          value: mirror.example.dev/serving
+      - name: local
+        image: localhost:5000/knative/local:v1
+      - name: busybox
+        image: busybox
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: config-controller
+  namespace: knative-serving
+data:
+  queueSidecarImage: gcr.io/knative-releases/github.com/knative/serving/cmd/queue@` + digest + `
+  registriesSkippingTagResolving: ko.local,dev.local
+  _example: |
+    image: not/an-image
+---
+apiVersion: build.knative.dev/v1alpha1
+kind: BuildTemplate
+metadata:
+  name: kaniko
+spec:
+  parameters:
+  - name: IMAGE
+    description: Where to publish the resulting image
+  - name: BUILDER_IMAGE
+    default: registry.example.dev:8443/kaniko-project/executor:v0.7.0
+  - name: DOCKERFILE
+    default: /workspace/Dockerfile
+  steps:
+  - name: build-and-push
+    image: ${BUILDER_IMAGE}
+    args: ["--destination=${IMAGE}"]
+`
+
+func TestFind(t *testing.T) {
+	refs, err := Find([]byte(manifest))
+	if err != nil {
+		t.Fatalf("Find() = %v", err)
+	}
+	const deployment = "Deployment knative-serving/controller"
+	const containers = "spec.template.spec.containers"
+	want := []Ref{
+		{"gcr.io/knative-releases/github.com/knative/serving/cmd/controller@" + digest, deployment, containers + "[controller].image"},
+		{"gcr.io/knative-releases/github.com/knative/serving/cmd/queue@" + digest, deployment, containers + "[controller].args[1]"},
+		{"gcr.io/knative-releases/github.com/knative/serving/cmd/logging@" + digest, deployment, containers + "[controller].args[2]"},
+		{"localhost:5000/knative/local:v1", deployment, containers + "[local].image"},
+		{"busybox", deployment, containers + "[busybox].image"},
+		{"gcr.io/knative-releases/github.com/knative/serving/cmd/queue@" + digest, "ConfigMap knative-serving/config-controller", "data.queueSidecarImage"},
+		{"registry.example.dev:8443/kaniko-project/executor:v0.7.0", "BuildTemplate kaniko", "spec.parameters[BUILDER_IMAGE].default"},
+	}
+	if !reflect.DeepEqual(refs, want) {
+		t.Errorf("Find() =\n%v\nwant:\n%v", refs, want)
+	}
+}
+
+func TestFindInvalid(t *testing.T) {
+	if _, err := Find([]byte("kind: [")); err == nil {
+		t.Error("Find(invalid) succeeded, want an error")
+	}
+}
+
+func TestPinned(t *testing.T) {
+	tests := []struct {
+		image string
+		want  bool
+	}{
+		{"gcr.io/knative-releases/github.com/knative/serving/cmd/queue@" + digest, true},
+		{"gcr.io/knative-releases/cmd/queue:v0.3.0@" + digest, true},
+		{"registry.local:5000/knative/queue@" + digest, true},
+		{"gcr.io/knative-nightly/ko@" + digest, true},
+		{"gcr.io/knative-releases/cmd/queue:v0.3.0", false},
+		{"gcr.io/knative-releases/cmd/queue@sha256:0123", false},
+		{"knative/queue@" + digest, false},
+		{"--image=gcr.io/knative-releases/cmd/queue@" + digest, false},
+		{"gcr.io/knative-releases/cmd/queue@" + digest + " extra", false},
+	}
+	for _, tt := range tests {
+		if got := pinned.MatchString(tt.image); got != tt.want {
+			t.Errorf("pinned.MatchString(%q) = %v, want %v", tt.image, got, tt.want)
+		}
+	}
+}
+
+func TestIsImage(t *testing.T) {
+	tests := []struct {
+		kind string
+		path string
+		key  string
+		s    string
+		m    string
+		want bool
+	}{
+		{"Deployment", "spec.template.spec.containers[x]", "image", "gcr.io/a/b:v1", "", true},
+		{"Deployment", "spec.template.spec.containers[x]", "image", "busybox", "", true},
+		{"Deployment", "spec.template.spec.containers[x].env[X]", "value", "gcr.io/a/b@" + digest, "", true},
+		{"Deployment", "spec.template.spec.containers[x].env[X]", "value", "gcr.io/a/b:v1", "", false},
+		{"Deployment", "spec.template.spec.containers[x]", "image", "", "", false},
+		{"BuildTemplate", "spec.steps[build]", "image", "${BUILDER_IMAGE}", "", false},
+		{"ConfigMap", "data", "queueSidecarImage", "gcr.io/a/queue:v1", "", true},
+		{"ConfigMap", "data", "_example", "image: a\nimage: b", "", false},
+		{"ConfigMap", "data", "registries", "ko.local,dev.local", "", false},
+		{"Secret", "data", "image", "gcr.io/a/b:v1", "", true},
+		{"Secret", "data", "myImage", "gcr.io/a/b:v1", "", false},
+		{"BuildTemplate", "spec.parameters[BUILDER_IMAGE]", "default", "gcr.io/kaniko-project/executor", "BUILDER_IMAGE", true},
+		{"BuildTemplate", "spec.parameters[DOCKERFILE]", "default", "/workspace/Dockerfile", "DOCKERFILE", false},
+		{"Deployment", "spec.parameters[BUILDER_IMAGE]", "default", "gcr.io/kaniko-project/executor", "BUILDER_IMAGE", false},
+	}
+	for _, tt := range tests {
+		w := walker{kind: tt.kind}
+		if got := w.isImage(tt.path, tt.key, tt.s, mapWithName(tt.m)); got != tt.want {
+			t.Errorf("isImage(%s, %s, %s, %q) = %v, want %v", tt.kind, tt.path, tt.key, tt.s, got, tt.want)
+		}
+	}
+}
+
+func TestSplit(t *testing.T) {
+	tests := []struct {
+		image    string
+		registry string
+		path     string
+	}{
+		{"busybox", "docker.io", "library/busybox"},
+		{"busybox:1.29", "docker.io", "library/busybox:1.29"},
+		{"knative/helloworld", "docker.io", "knative/helloworld"},
+		{"gcr.io/knative-releases/github.com/knative/serving/cmd/queue@" + digest, "gcr.io", "knative-releases/github.com/knative/serving/cmd/queue@" + digest},
+		{"registry.local:5000/knative/queue:v1", "registry.local:5000", "knative/queue:v1"},
+		{"localhost/knative/queue", "localhost", "knative/queue"},
+		{"localhost:5000/queue", "localhost:5000", "queue"},
+	}
+	for _, tt := range tests {
+		registry, path := Split(tt.image)
+		if registry != tt.registry || path != tt.path {
+			t.Errorf("Split(%q) = %q, %q, want %q, %q", tt.image, registry, path, tt.registry, tt.path)
+		}
+	}
+}
+
+func TestDestination(t *testing.T) {
+	tests := []struct {
+		image    string
+		registry string
+		want     string
+	}{
+		{"gcr.io/knative-releases/cmd/queue@" + digest, "registry.internal/knative", "registry.internal/knative/knative-releases/cmd/queue@" + digest},
+		{"gcr.io/knative-releases/cmd/queue:v0.3.0@" + digest, "registry.internal:5000/", "registry.internal:5000/knative-releases/cmd/queue:v0.3.0@" + digest},
+		{"busybox", "registry.internal", "registry.internal/library/busybox"},
+		{"localhost:5000/ko/queue:v1", "registry.internal", "registry.internal/ko/queue:v1"},
+	}
+	for _, tt := range tests {
+		if got := Destination(tt.image, tt.registry); got != tt.want {
+			t.Errorf("Destination(%q, %q) = %q, want %q", tt.image, tt.registry, got, tt.want)
+		}
+	}
+}
+
+func TestDigest(t *testing.T) {
+	if got := Digest("gcr.io/a/b:v1@" + digest); got != digest {
+		t.Errorf("Digest(pinned) = %q, want %q", got, digest)
+	}
+	if got := Digest("gcr.io/a/b:v1"); got != "" {
+		t.Errorf("Digest(unpinned) = %q, want none", got)
+	}
+}
+
+// mapWithName returns a map holding name, like a BuildTemplate parameter.
+func mapWithName(name string) yaml.MapSlice {
+	if name == "" {
+		return nil
+	}
+	return yaml.MapSlice{{Key: "name", Value: name}}
+}
+
+func TestRelocate(t *testing.T) {
+	const registry = "registry.internal:5000/knative"
+	out, mapping, err := Relocate([]byte(manifest), registry)
+	if err != nil {
+		t.Fatalf("Relocate() = %v", err)
+	}
+	wantMapping := map[string]string{
+		"gcr.io/knative-releases/github.com/knative/serving/cmd/controller@" + digest: registry + "/knative-releases/github.com/knative/serving/cmd/controller@" + digest,
+		"gcr.io/knative-releases/github.com/knative/serving/cmd/queue@" + digest:      registry + "/knative-releases/github.com/knative/serving/cmd/queue@" + digest,
+		"gcr.io/knative-releases/github.com/knative/serving/cmd/logging@" + digest:    registry + "/knative-releases/github.com/knative/serving/cmd/logging@" + digest,
+		"localhost:5000/knative/local:v1":                                             registry + "/knative/local:v1",
+		"busybox":                                                                     registry + "/library/busybox",
+		"registry.example.dev:8443/kaniko-project/executor:v0.7.0":                    registry + "/kaniko-project/executor:v0.7.0",
+	}
+	if !reflect.DeepEqual(mapping, wantMapping) {
+		t.Errorf("Relocate() mapping =\n%v\nwant:\n%v", mapping, wantMapping)
+	}
+
+	// Every image found in the relocated manifest is under registry, and
+	// keeps its digest.
+	refs, err := Find(out)
+	if err != nil {
+		t.Fatalf("Find(relocated) = %v", err)
+	}
+	found := map[string]bool{}
+	for _, r := range refs {
+		found[r.Image] = true
+		if !strings.HasPrefix(r.Image, registry+"/") {
+			t.Errorf("%s %s = %q, want an image under %s", r.Object, r.Path, r.Image, registry)
+		}
+	}
+	for image, dest := range wantMapping {
+		if !found[dest] {
+			t.Errorf("Relocated manifest does not use %q", dest)
+		}
+		if Digest(dest) != Digest(image) {
+			t.Errorf("Relocate(%q) = %q, want digest %q", image, dest, Digest(image))
+		}
+	}
+
+	// Strings which are not images are left alone.
+	for _, s := range []string{
+		"--logging-image=" + registry + "/knative-releases/github.com/knative/serving/cmd/logging@" + digest,
+		"--not-pinned=gcr.io/knative-releases/cmd/other:latest",
+		"value: mirror.example.dev/serving",
+		"registriesSkippingTagResolving: ko.local,dev.local",
+		"image: not/an-image",
+		"image: ${BUILDER_IMAGE}",
+		"--destination=${IMAGE}",
+		"default: /workspace/Dockerfile",
+	} {
+		if !strings.Contains(string(out), s) {
+			t.Errorf("Relocated manifest does not contain %q:\n%s", s, out)
+		}
+	}
+}
+
+func TestRelocateNoImages(t *testing.T) {
+	const configMap = "kind: ConfigMap\ndata: {a: b}\n"
+	out, mapping, err := Relocate([]byte(configMap), "registry.internal")
+	if err != nil {
+		t.Fatalf("Relocate() = %v", err)
+	}
+	if string(out) != configMap || len(mapping) != 0 {
+		t.Errorf("Relocate() = %q, %v, want the manifest unchanged", out, mapping)
+	}
+}
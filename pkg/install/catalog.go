@@ -0,0 +1,248 @@
+package install
+
+import (
+	// Needed for go:embed of the default catalog.
+	_ "embed"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/evankanderson/knuts/pkg"
+	yaml "gopkg.in/yaml.v2"
+)
+
+//go:embed catalog.yaml
+var defaultCatalog []byte
+
+// Catalog is the on-disk (YAML or JSON) description of the known Knative
+// releases and their installable Components.
+type Catalog struct {
+	Releases []Release `yaml:"releases"`
+}
+
+// Release is a set of Components which are tested together, e.g. "v0.2.2".
+type Release struct {
+	Version     string      `yaml:"version"`
+	Description string      `yaml:"description"`
+	Components  []Component `yaml:"components"`
+}
+
+// componentSpec is the serialized form of a Component, which exposes the
+// fields used for dependency resolution.
+type componentSpec struct {
+	Name        string        `yaml:"name"`
+	Description string        `yaml:"description"`
+	Version     string        `yaml:"version"`
+	Yaml        string        `yaml:"yaml"`
+	SHA256      string        `yaml:"sha256"`
+	Namespaces  []string      `yaml:"namespaces"`
+	Hidden      bool          `yaml:"hidden"`
+	Provides    string        `yaml:"provides"`
+	Gateway     *Gateway      `yaml:"gateway"`
+	Preferred   bool          `yaml:"preferred"`
+	Deps        []string      `yaml:"deps"`
+	Config      []ConfigPatch `yaml:"config"`
+	Kubernetes  VersionRange  `yaml:"kubernetes"`
+	Replaces    []string      `yaml:"replaces"`
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (c *Component) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	s := componentSpec{}
+	if err := unmarshal(&s); err != nil {
+		return err
+	}
+	*c = Component{
+		Name:        s.Name,
+		Description: s.Description,
+		Version:     s.Version,
+		Yaml:        s.Yaml,
+		Digest:      s.SHA256,
+		Namespaces:  s.Namespaces,
+		hidden:      s.Hidden,
+		provides:    s.Provides,
+		Gateway:     s.Gateway,
+		preferred:   s.Preferred,
+		deps:        s.Deps,
+		Config:      s.Config,
+		Kubernetes:  s.Kubernetes,
+		replaces:    s.Replaces,
+	}
+	return nil
+}
+
+// ParseCatalog reads a Catalog from YAML or JSON data and checks that all
+// dependencies can be resolved.
+func ParseCatalog(data []byte) (*Catalog, error) {
+	c := &Catalog{}
+	if err := yaml.UnmarshalStrict(data, c); err != nil {
+		return nil, fmt.Errorf("Unable to parse catalog: %v", err)
+	}
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+	for _, r := range c.Releases {
+		for i := range r.Components {
+			if r.Components[i].Version == "" {
+				r.Components[i].Version = r.Version
+			}
+		}
+	}
+	return c, nil
+}
+
+// LoadCatalog reads the Catalog at path and makes it the set of Releases
+// used by VersionsAsFlag and UseRelease.
+func LoadCatalog(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("Unable to read catalog %q: %v", path, err)
+	}
+	c, err := ParseCatalog(data)
+	if err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	catalog = c
+	return nil
+}
+
+// VersionsAsFlag returns the known releases in the current catalog as a
+// Select flag.
+func VersionsAsFlag() pkg.Select {
+	o := map[string]pkg.Option{}
+	for _, r := range catalog.Releases {
+		o[r.Version] = pkg.Option{
+			Description: r.Description,
+			Data:        r,
+		}
+	}
+	return pkg.Select{
+		Description: "Which Knative release to install",
+		Options:     o,
+	}
+}
+
+// Latest returns the version of the newest release in the current catalog.
+func Latest() string {
+	return catalog.Releases[0].Version
+}
+
+// UseRelease makes the Components from the named release the set used by
+// ComponentsAsFlag and DefaultResolver.
+func UseRelease(version string) error {
+	for _, r := range catalog.Releases {
+		if r.Version == version {
+			r.use()
+			return nil
+		}
+	}
+	return fmt.Errorf("Unknown release %q", version)
+}
+
+// InstalledRelease returns the release in the catalog whose Component
+// versions best match the release labels found in cluster (see
+// Component.InstalledVersion), or nil if none match. Releases are tried
+// newest first, so ties go to the newer release.
+func InstalledRelease(cluster *pkg.Cluster) (*Release, error) {
+	// Releases share many Components, so only look each one up once.
+	found := map[string]string{}
+	var best *Release
+	bestMatches := 0
+	for i, r := range catalog.Releases {
+		matches := 0
+		for _, c := range r.Components {
+			key := fmt.Sprint(c.Namespaces)
+			v, ok := found[key]
+			if !ok {
+				var err error
+				if v, err = c.InstalledVersion(cluster); err != nil {
+					return nil, err
+				}
+				found[key] = v
+			}
+			if v != "" && v == c.Version {
+				matches++
+			}
+		}
+		if matches > bestMatches {
+			best, bestMatches = &catalog.Releases[i], matches
+		}
+	}
+	return best, nil
+}
+
+// Successor returns the Component in the current release which upgrades
+// the Component called name in another release: the one with the same name,
+// or which `replaces` it.
+func Successor(name string) (Component, bool) {
+	for _, c := range components {
+		if c.Name == name {
+			return c, true
+		}
+	}
+	for _, c := range components {
+		for _, r := range c.replaces {
+			if r == name {
+				return c, true
+			}
+		}
+	}
+	return Component{}, false
+}
+
+func (c *Catalog) validate() error {
+	if len(c.Releases) == 0 {
+		return fmt.Errorf("Catalog has no releases")
+	}
+	versions := map[string]bool{}
+	for _, r := range c.Releases {
+		if r.Version == "" {
+			return fmt.Errorf("Release with description %q has no version", r.Description)
+		}
+		if versions[r.Version] {
+			return fmt.Errorf("Release %q is defined more than once", r.Version)
+		}
+		versions[r.Version] = true
+		if err := r.validate(); err != nil {
+			return fmt.Errorf("Release %q: %v", r.Version, err)
+		}
+	}
+	return nil
+}
+
+func (r *Release) validate() error {
+	names := map[string]bool{}
+	provided := map[string]bool{}
+	for _, comp := range r.Components {
+		if comp.Name == "" {
+			return fmt.Errorf("Component with description %q has no name", comp.Description)
+		}
+		if names[comp.Name] {
+			return fmt.Errorf("Component %q is defined more than once", comp.Name)
+		}
+		names[comp.Name] = true
+		if comp.provides != "" {
+			provided[comp.provides] = true
+		}
+		if err := comp.Kubernetes.validate(); err != nil {
+			return fmt.Errorf("Component %q: %v", comp.Name, err)
+		}
+		for _, p := range comp.Config {
+			if err := p.validate(); err != nil {
+				return fmt.Errorf("Component %q: %v", comp.Name, err)
+			}
+		}
+	}
+	for _, comp := range r.Components {
+		for _, d := range comp.deps {
+			if !names[d] && !provided[d] {
+				return fmt.Errorf("Component %q depends on unknown %q", comp.Name, d)
+			}
+		}
+	}
+	return nil
+}
+
+// use makes r the release used by ComponentsAsFlag and DefaultResolver.
+func (r *Release) use() {
+	components = r.Components
+}
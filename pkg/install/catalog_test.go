@@ -0,0 +1,32 @@
+package install
+
+import "testing"
+
+func TestSuccessor(t *testing.T) {
+	defer func(saved []Component) { components = saved }(components)
+	for i, r := range catalog.Releases {
+		if err := UseRelease(r.Version); err != nil {
+			t.Fatal(err)
+		}
+		// Every Component of an older release can be upgraded to r.
+		for _, older := range catalog.Releases[i+1:] {
+			for _, c := range older.Components {
+				if _, ok := Successor(c.Name); !ok {
+					t.Errorf("%s %s has no successor in %s", older.Version, c.Name, r.Version)
+				}
+			}
+		}
+	}
+
+	if err := UseRelease("v0.2.2"); err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]string{"istio": "istio-sidecar", "serving": "serving"} {
+		if got, ok := Successor(name); !ok || got.Name != want {
+			t.Errorf("Successor(%s) = %s, %v, want %s", name, got.Name, ok, want)
+		}
+	}
+	if got, ok := Successor("gloo"); ok {
+		t.Errorf("Successor(gloo) = %s, want none in v0.2.2", got.Name)
+	}
+}
@@ -0,0 +1,78 @@
+package install
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/evankanderson/knuts/pkg"
+	"github.com/evankanderson/knuts/pkg/overlay"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// ConfigPatch sets keys in a ConfigMap installed by another Component, e.g.
+// to point Knative Serving's config-network at an ingress provider.
+type ConfigPatch struct {
+	Namespace string            `json:"namespace" yaml:"namespace"`
+	ConfigMap string            `json:"configmap" yaml:"configmap"`
+	Data      map[string]string `json:"data" yaml:"data"`
+}
+
+func (p ConfigPatch) validate() error {
+	if p.Namespace == "" || p.ConfigMap == "" {
+		return fmt.Errorf("config patch needs a namespace and configmap")
+	}
+	if len(p.Data) == 0 {
+		return fmt.Errorf("config patch for %s/%s has no data", p.Namespace, p.ConfigMap)
+	}
+	return nil
+}
+
+func (p ConfigPatch) target() overlay.Target {
+	return overlay.Target{Kind: "ConfigMap", Namespace: p.Namespace, Name: p.ConfigMap}
+}
+
+// Configure arranges for the config patches of every Component in the plan
+// to be applied (via cluster's Overlay) when the ConfigMaps they target are
+// installed. Since providers are installed before the Components which
+// depend on them, this is usually later in the same plan.
+func (p *Plan) Configure(cluster *pkg.Cluster) {
+	for _, s := range p.Steps {
+		for _, c := range s.Component.Config {
+			keys := []string{}
+			for k := range c.Data {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			data := yaml.MapSlice{}
+			for _, k := range keys {
+				data = append(data, yaml.MapItem{Key: k, Value: c.Data[k]})
+			}
+			if cluster.Overlay == nil {
+				cluster.Overlay = &overlay.Overlay{}
+			}
+			cluster.Overlay.Add(c.target(), yaml.MapSlice{{Key: "data", Value: data}})
+		}
+	}
+}
+
+// PatchConfig patches the live ConfigMaps in cluster targeted by the plan's config
+// patches which were not installed (and so not patched by Configure), e.g.
+// when switching the ingress provider of an existing install.
+func (p *Plan) PatchConfig(cluster *pkg.Cluster) error {
+	for _, s := range p.Steps {
+		for _, c := range s.Component.Config {
+			if cluster.Overlay != nil && cluster.Overlay.Used(c.target()) {
+				continue
+			}
+			patch, err := json.Marshal(map[string]interface{}{"data": c.Data})
+			if err != nil {
+				return err
+			}
+			if err := cluster.Patch("configmap", c.Namespace, c.ConfigMap, patch); err != nil {
+				return fmt.Errorf("Failed to configure %s for %s: %v", c.ConfigMap, s.Name, err)
+			}
+		}
+	}
+	return nil
+}
@@ -2,6 +2,8 @@ package install
 
 import (
 	"fmt"
+	"strings"
+	"time"
 
 	"github.com/evankanderson/knuts/pkg"
 )
@@ -10,11 +12,36 @@ import (
 type Component struct {
 	Name        string
 	Description string
-	Yaml        string
-	hidden      bool
-	provides    string
-	preferred   bool
-	deps        []string
+	// Version is the upstream release of the Component, which defaults to
+	// the Release it is part of.
+	Version string
+	Yaml    string
+	// Digest is the pinned SHA-256 of the Yaml manifest; see pkg.Fetch.
+	Digest string
+	// Namespaces lists the namespaces whose Deployments must be Available
+	// before the Component is considered ready.
+	Namespaces []string
+	// Config patches ConfigMaps installed by other Components once both
+	// are installed; see Plan.Configure.
+	Config []ConfigPatch
+	// Kubernetes is the range of cluster versions the Component supports.
+	Kubernetes VersionRange
+	// Gateway is the Service through which an ingress provider receives
+	// external traffic, if any.
+	Gateway   *Gateway
+	hidden    bool
+	provides  string
+	preferred bool
+	deps      []string
+	// replaces lists the names of Components in older releases which this
+	// Component upgrades, e.g. when a Component is renamed.
+	replaces []string
+}
+
+// Gateway locates an ingress provider's gateway Service.
+type Gateway struct {
+	Namespace string `json:"namespace" yaml:"namespace"`
+	Name      string `json:"name" yaml:"name"`
 }
 
 // ComponentsAsFlag returns the public versions of the components list as a
@@ -37,119 +64,116 @@ func ComponentsAsFlag() pkg.MultiSelect {
 
 // Expand returns the transitive set of dependencies for the Component
 // (prompting if needed), given the currently selected set of Components
-// to install.
+// to install, in install order. It is a wrapper for DefaultResolver; on
+// errors (such as conflicting selections) it prints the error and returns
+// selected unchanged.
 func (c Component) Expand(selected []Component) []Component {
-	for _, x := range selected {
-		if x.Name == c.Name {
-			return selected
+	names := []string{c.Name}
+	for _, s := range selected {
+		names = append(names, s.Name)
+	}
+	plan, err := DefaultResolver().Resolve(names)
+	if err != nil {
+		fmt.Println(err)
+		return selected
+	}
+	ret := []Component{}
+	for _, s := range plan.Steps {
+		ret = append(ret, s.Component)
+	}
+	return ret
+}
+
+// Install applies the Component's manifest to cluster.
+func (c Component) Install(cluster *pkg.Cluster) error {
+	return cluster.Kubectl(c.Yaml, c.Digest)
+}
+
+// Uninstall deletes the objects in the Component's manifest from cluster.
+func (c Component) Uninstall(cluster *pkg.Cluster) error {
+	return cluster.KubectlDelete(c.Yaml, c.Digest)
+}
+
+// Installed reports whether any of the Component's objects are present in
+// cluster.
+func (c Component) Installed(cluster *pkg.Cluster) (bool, error) {
+	return cluster.Exists(c.Yaml, c.Digest)
+}
+
+// InstalledVersion returns the release of the Component found in cluster,
+// based on the `*.knative.dev/release` labels on
+// its Deployments. It returns "" if no release label was found.
+func (c Component) InstalledVersion(cluster *pkg.Cluster) (string, error) {
+	for _, ns := range c.Namespaces {
+		versions, err := cluster.ReleaseLabels(ns)
+		if err != nil {
+			return "", err
+		}
+		switch len(versions) {
+		case 0:
+			continue
+		case 1:
+			return versions[0], nil
+		default:
+			return "", fmt.Errorf("Found multiple releases of %s in %s: %s", c.Name, ns, strings.Join(versions, ", "))
 		}
 	}
-	ret := selected[:]
-
-	unresolved := c.deps
-DEP:
-	for len(unresolved) > 0 {
-		dep := unresolved[0]
-		unresolved = unresolved[1:]
-
-		if opts, ok := providers[dep]; ok {
-			// Is a "provides", resolve it.
-			// Select an opt via menu
-			menu := map[string]pkg.Option{}
-			for _, opt := range opts {
-				for _, c := range ret {
-					if c.Name == opt {
-						fmt.Printf("Resolved %q to %q\n", dep, opt)
-						continue DEP
-					}
-				}
-				choice := componentMap[opt]
-				menu[opt] = pkg.Option{Description: choice.Description, Data: choice}
-				if choice.preferred {
-					unresolved = append([]string{opt}, unresolved...)
-					continue DEP
-				}
-			}
-			// TODO: this should be a single-select
-			prompt := &pkg.MultiSelect{
-				Description: fmt.Sprintf("Select an implementation for %q", dep),
-				Options:     menu,
-			}
-			dep = prompt.Get().([]pkg.Option)[0].Data.(Component).Name
+	return "", nil
+}
+
+// WaitReady waits up to timeout for the Component's CRDs to be Established
+// and its Deployments to be Available in cluster.
+func (c Component) WaitReady(cluster *pkg.Cluster, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	if err := cluster.WaitForCRDs(c.Yaml, c.Digest, timeout); err != nil {
+		return fmt.Errorf("CRDs for %s not established: %v", c.Name, err)
+	}
+	for _, ns := range c.Namespaces {
+		remaining := time.Until(deadline).Round(time.Second)
+		if remaining <= 0 {
+			return fmt.Errorf("Timed out waiting for %s", c.Name)
 		}
-		if resolved, ok := componentMap[dep]; ok {
-			// Is a real dependency
-			ret = resolved.Expand(ret)
+		if err := cluster.WaitForDeployments(ns, remaining); err != nil {
+			return fmt.Errorf("Deployments for %s in %s not available: %v", c.Name, ns, err)
 		}
 	}
-	return append(ret, c)
+	return nil
 }
 
-var (
-	components = []Component{
-		{
-			Name:        "build",
-			Description: "Knative build: cluster-hosted container build",
-			Yaml:        "https://github.com/knative/serving/releases/download/v0.2.2/build.yaml",
-		},
-		{
-			Name:        "serving",
-			Description: "Knative serving: scale from zero stateless web services",
-			Yaml:        "https://github.com/knative/serving/releases/download/v0.2.2/serving.yaml",
-			deps:        []string{"istio"},
-		},
-		{
-			Name:        "eventing",
-			Description: "Knative eventing: Channels and orchestration",
-			Yaml:        "https://github.com/knative/eventing/releases/download/v0.2.1/release.yaml",
-			deps:        []string{"istio-sidecar"},
-		},
-		{
-			Name:        "eventing-sources",
-			Description: "Knative event sources",
-			Yaml:        "https://github.com/knative/eventing-sources/releases/download/v0.2.1/release.yaml",
-			deps:        []string{"serving", "istio-sidecar"},
-		},
-		{
-			Name:        "monitoring",
-			Description: "Monitoring and instrumentation for Knative",
-			Yaml:        "https://github.com/knative/serving/releases/download/v0.2.2/monitoring.yaml",
-		},
-		{
-			Name:        "istio-sidecar",
-			Description: "Knative tested version of Istio with sidecar",
-			Yaml:        "https://github.com/knative/serving/releases/download/v0.2.2/istio.yaml",
-			hidden:      true,
-			provides:    "istio",
-			deps:        []string{"istio-crd"},
-			preferred:   true,
-		},
-		{
-			Name:        "istio-lean",
-			Description: "Knative tested version of Istio without sidecar",
-			Yaml:        "https://github.com/knative/serving/releases/download/v0.2.2/istio-lean.yaml",
-			hidden:      true,
-			provides:    "istio",
-			deps:        []string{"istio-crd"},
-		},
-		{
-			Name:        "istio-crd",
-			Description: "Istio CRDs",
-			Yaml:        "https://github.com/knative/serving/releases/download/v0.2.2/istio-crds.yaml",
-			hidden:      true,
-		},
+// Components returns all Components in the current release, including
+// hidden ones.
+func Components() []Component {
+	return components
+}
+
+// Gateways returns the distinct gateway Services of the ingress providers in
+// all releases of the catalog, in catalog order.
+func Gateways() []Gateway {
+	seen := map[Gateway]bool{}
+	ret := []Gateway{}
+	for _, r := range catalog.Releases {
+		for _, c := range r.Components {
+			if c.Gateway != nil && !seen[*c.Gateway] {
+				seen[*c.Gateway] = true
+				ret = append(ret, *c.Gateway)
+			}
+		}
 	}
+	return ret
+}
 
-	// providers tracks which components provide a dependency.
-	providers    = map[string][]string{}
-	componentMap = map[string]Component{}
+var (
+	// catalog is the set of known releases, set by LoadCatalog.
+	catalog *Catalog
+
+	// components is the current release, set by UseRelease.
+	components []Component
 )
 
 func init() {
-	for _, c := range components {
-		if c.provides != "" {
-			providers[c.provides] = append(providers[c.provides], c.Name)
-		}
-		componentMap[c.Name] = c
+	c, err := ParseCatalog(defaultCatalog)
+	if err != nil {
+		panic(fmt.Sprintf("Built-in catalog is invalid: %v", err))
 	}
+	catalog = c
 }
@@ -0,0 +1,71 @@
+package install
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// VersionRange is an inclusive range of Kubernetes minor versions, like
+// "1.11". Either end may be empty.
+type VersionRange struct {
+	Min string `json:"min,omitempty" yaml:"min"`
+	Max string `json:"max,omitempty" yaml:"max"`
+}
+
+func (r VersionRange) String() string {
+	switch {
+	case r.Min == "" && r.Max == "":
+		return "any"
+	case r.Max == "":
+		return ">= " + r.Min
+	case r.Min == "":
+		return "<= " + r.Max
+	}
+	return r.Min + " - " + r.Max
+}
+
+func (r VersionRange) validate() error {
+	for _, v := range []string{r.Min, r.Max} {
+		if v == "" {
+			continue
+		}
+		if _, _, err := ParseMinorVersion(v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Contains reports whether Kubernetes major.minor is within the range.
+func (r VersionRange) Contains(major int, minor int) bool {
+	if r.Min != "" {
+		if lo, lm, err := ParseMinorVersion(r.Min); err == nil && (major < lo || major == lo && minor < lm) {
+			return false
+		}
+	}
+	if r.Max != "" {
+		if hi, hm, err := ParseMinorVersion(r.Max); err == nil && (major > hi || major == hi && minor > hm) {
+			return false
+		}
+	}
+	return true
+}
+
+// ParseMinorVersion parses a Kubernetes version like "1.11", "v1.11.5" or
+// "1.11+" (as reported by some providers) into its major and minor parts.
+func ParseMinorVersion(v string) (int, int, error) {
+	parts := strings.SplitN(strings.TrimPrefix(v, "v"), ".", 3)
+	if len(parts) < 2 {
+		return 0, 0, fmt.Errorf("Unable to parse Kubernetes version %q", v)
+	}
+	major, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, 0, fmt.Errorf("Unable to parse Kubernetes version %q", v)
+	}
+	minor, err := strconv.Atoi(strings.TrimSuffix(parts[1], "+"))
+	if err != nil {
+		return 0, 0, fmt.Errorf("Unable to parse Kubernetes version %q", v)
+	}
+	return major, minor, nil
+}
@@ -0,0 +1,378 @@
+package install
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/evankanderson/knuts/pkg"
+)
+
+// Chooser selects one of several Components which provide the virtual
+// dependency dep. It is only called when the choice cannot be made from
+// the catalog (no provider is already selected or preferred).
+type Chooser func(dep string, candidates []Component) (Component, error)
+
+// Resolver computes install plans from a set of Components.
+type Resolver struct {
+	components map[string]Component
+	providers  map[string][]string
+	prefer     map[string]Resolution
+	choose     Chooser
+}
+
+// Plan is the result of resolving a set of requested Components.
+type Plan struct {
+	// Steps is the install order; each Component appears after all of its
+	// dependencies.
+	Steps []Step `json:"steps" yaml:"steps"`
+	// Providers records which Component was chosen for each virtual
+	// dependency, sorted by dependency.
+	Providers []Resolution `json:"providers" yaml:"providers"`
+}
+
+// Step is a single Component to install as part of a Plan.
+type Step struct {
+	Component Component `json:"-" yaml:"-"`
+	Name      string    `json:"name" yaml:"name"`
+	Manifest  string    `json:"manifest" yaml:"manifest"`
+	Digest    string    `json:"sha256,omitempty" yaml:"sha256,omitempty"`
+	// Requested is true if the Component was named in the request, rather
+	// than only being a dependency.
+	Requested  bool     `json:"requested" yaml:"requested"`
+	RequiredBy []string `json:"requiredBy,omitempty" yaml:"requiredBy,omitempty"`
+	// Config lists the ConfigMap changes the Component makes.
+	Config []ConfigPatch `json:"config,omitempty" yaml:"config,omitempty"`
+}
+
+// Resolution describes how a virtual dependency was resolved.
+type Resolution struct {
+	Dependency string `json:"dependency" yaml:"dependency"`
+	Provider   string `json:"provider" yaml:"provider"`
+	Reason     string `json:"reason" yaml:"reason"`
+}
+
+// Reasons reported in a Resolution.
+const (
+	ReasonSelected  = "already selected"
+	ReasonPrefer    = "--prefer"
+	ReasonPreferred = "catalog preferred"
+	ReasonOnly      = "only provider"
+	ReasonChosen    = "chosen interactively"
+	ReasonInstalled = "installed"
+	ReasonLocked    = "lockfile"
+)
+
+// NewResolver creates a Resolver over components, using choose to pick
+// between providers of virtual dependencies.
+func NewResolver(components []Component, choose Chooser) *Resolver {
+	r := &Resolver{
+		components: map[string]Component{},
+		providers:  map[string][]string{},
+		prefer:     map[string]Resolution{},
+		choose:     choose,
+	}
+	for _, c := range components {
+		r.components[c.Name] = c
+		if c.provides != "" {
+			r.providers[c.provides] = append(r.providers[c.provides], c.Name)
+		}
+	}
+	return r
+}
+
+// DefaultResolver creates a Resolver over the current release (see
+// UseRelease) which prompts to choose between providers if a terminal is
+// available.
+func DefaultResolver() *Resolver {
+	if pkg.Interactive() {
+		return NewResolver(components, PromptChooser)
+	}
+	return NewResolver(components, NoPromptChooser)
+}
+
+// Prefer pins the provider for virtual dependencies, as a map from the
+// virtual dependency (e.g. "ingress") to the Component name (e.g.
+// "istio-lean"). This takes precedence over the catalog's preferred
+// provider.
+func (r *Resolver) Prefer(prefs map[string]string) error {
+	for dep, name := range prefs {
+		names, ok := r.providers[dep]
+		if !ok {
+			return fmt.Errorf("%q is not provided by any component", dep)
+		}
+		found := false
+		for _, n := range names {
+			if n == name {
+				found = true
+			}
+		}
+		if !found {
+			return fmt.Errorf("%q does not provide %q; choose one of: %s", name, dep, strings.Join(names, ", "))
+		}
+		r.prefer[dep] = Resolution{Dependency: dep, Provider: name, Reason: ReasonPrefer}
+	}
+	return nil
+}
+
+// PreferInstalled pins the provider for each virtual dependency which has
+// exactly one installed provider, unless already set by Prefer.
+func (r *Resolver) PreferInstalled(installed map[string]bool) {
+	for dep, names := range r.providers {
+		if _, ok := r.prefer[dep]; ok {
+			continue
+		}
+		found := []string{}
+		for _, n := range names {
+			if installed[n] {
+				found = append(found, n)
+			}
+		}
+		if len(found) == 1 {
+			r.prefer[dep] = Resolution{Dependency: dep, Provider: found[0], Reason: ReasonInstalled}
+		}
+	}
+}
+
+// PromptChooser is a Chooser which interactively asks the user.
+func PromptChooser(dep string, candidates []Component) (Component, error) {
+	menu := map[string]pkg.Option{}
+	for _, c := range candidates {
+		menu[c.Name] = pkg.Option{Description: c.Description, Data: c}
+	}
+	prompt := &pkg.Select{
+		Description: fmt.Sprintf("Select an implementation for %q", dep),
+		Options:     menu,
+	}
+	choice, ok := prompt.Get().(pkg.Option).Data.(Component)
+	if !ok {
+		return Component{}, fmt.Errorf("No implementation selected for %q", dep)
+	}
+	return choice, nil
+}
+
+// NoPromptChooser is a Chooser for non-interactive use, which fails with a
+// list of the valid choices.
+func NoPromptChooser(dep string, candidates []Component) (Component, error) {
+	names := []string{}
+	for _, c := range candidates {
+		names = append(names, c.Name)
+	}
+	sort.Strings(names)
+	return Component{}, fmt.Errorf("Multiple components provide %q (%s); use --prefer %s=<component> to choose one", dep, strings.Join(names, ", "), dep)
+}
+
+// Gateway returns the gateway Service of the ingress provider in the Plan,
+// or nil if the Plan does not install one.
+func (p *Plan) Gateway() *Gateway {
+	for _, s := range p.Steps {
+		if s.Component.Gateway != nil {
+			return s.Component.Gateway
+		}
+	}
+	return nil
+}
+
+// Resolve computes the transitive dependencies of the named Components and
+// returns them in install order. It returns an error if a name is unknown,
+// the dependencies contain a cycle, or two selected Components provide the
+// same virtual dependency.
+func (r *Resolver) Resolve(names []string) (*Plan, error) {
+	selected := map[string]bool{}
+	providers := map[string]Resolution{}
+
+	pending := append([]string{}, names...)
+	sort.Strings(pending)
+	virtual := []string{}
+	for len(pending) > 0 || len(virtual) > 0 {
+		// Collect concrete dependencies first, so that virtual
+		// dependencies can be satisfied by anything already selected.
+		for len(pending) > 0 {
+			name := pending[0]
+			pending = pending[1:]
+			if selected[name] {
+				continue
+			}
+			c, ok := r.components[name]
+			if !ok {
+				return nil, fmt.Errorf("Unknown component %q", name)
+			}
+			selected[name] = true
+			for _, d := range c.deps {
+				if _, ok := r.providers[d]; ok {
+					virtual = append(virtual, d)
+				} else {
+					pending = append(pending, d)
+				}
+			}
+		}
+		if len(virtual) == 0 {
+			break
+		}
+		dep := virtual[0]
+		virtual = virtual[1:]
+		if _, ok := providers[dep]; ok {
+			continue
+		}
+		choice, reason, err := r.provider(dep, selected)
+		if err != nil {
+			return nil, err
+		}
+		providers[dep] = Resolution{Dependency: dep, Provider: choice, Reason: reason}
+		pending = append(pending, choice)
+	}
+
+	if err := r.checkConflicts(selected); err != nil {
+		return nil, err
+	}
+
+	resolved := map[string]string{}
+	for d, p := range providers {
+		resolved[d] = p.Provider
+	}
+	order, err := r.sort(selected, resolved)
+	if err != nil {
+		return nil, err
+	}
+
+	requested := map[string]bool{}
+	for _, n := range names {
+		requested[n] = true
+	}
+	requiredBy := map[string][]string{}
+	for _, name := range order {
+		for _, d := range r.components[name].deps {
+			if p, ok := resolved[d]; ok {
+				d = p
+			}
+			requiredBy[d] = append(requiredBy[d], name)
+		}
+	}
+
+	plan := &Plan{Providers: []Resolution{}}
+	for _, name := range order {
+		c := r.components[name]
+		plan.Steps = append(plan.Steps, Step{
+			Component:  c,
+			Name:       c.Name,
+			Manifest:   c.Yaml,
+			Digest:     c.Digest,
+			Requested:  requested[name],
+			RequiredBy: requiredBy[name],
+			Config:     c.Config,
+		})
+	}
+	deps := []string{}
+	for d := range providers {
+		deps = append(deps, d)
+	}
+	sort.Strings(deps)
+	for _, d := range deps {
+		plan.Providers = append(plan.Providers, providers[d])
+	}
+	return plan, nil
+}
+
+// provider picks the Component which satisfies the virtual dependency dep,
+// and the reason it was picked.
+func (r *Resolver) provider(dep string, selected map[string]bool) (string, string, error) {
+	if p, ok := r.prefer[dep]; ok {
+		return p.Provider, p.Reason, nil
+	}
+	candidates := []Component{}
+	for _, name := range r.providers[dep] {
+		if selected[name] {
+			return name, ReasonSelected, nil
+		}
+		candidates = append(candidates, r.components[name])
+	}
+	for _, c := range candidates {
+		if c.preferred {
+			return c.Name, ReasonPreferred, nil
+		}
+	}
+	if len(candidates) == 1 {
+		return candidates[0].Name, ReasonOnly, nil
+	}
+	if r.choose == nil {
+		return "", "", fmt.Errorf("No provider chosen for %q", dep)
+	}
+	choice, err := r.choose(dep, candidates)
+	if err != nil {
+		return "", "", err
+	}
+	for _, c := range candidates {
+		if c.Name == choice.Name {
+			return c.Name, ReasonChosen, nil
+		}
+	}
+	return "", "", fmt.Errorf("%q does not provide %q", choice.Name, dep)
+}
+
+// checkConflicts ensures that at most one selected Component provides each
+// virtual dependency.
+func (r *Resolver) checkConflicts(selected map[string]bool) error {
+	deps := []string{}
+	for d := range r.providers {
+		deps = append(deps, d)
+	}
+	sort.Strings(deps)
+	for _, d := range deps {
+		found := []string{}
+		for _, name := range r.providers[d] {
+			if selected[name] {
+				found = append(found, name)
+			}
+		}
+		if len(found) > 1 {
+			return fmt.Errorf("Components %s all provide %q; select only one", strings.Join(found, ", "), d)
+		}
+	}
+	return nil
+}
+
+// sort returns the selected Components in dependency order, breaking ties
+// by name so that the result is deterministic.
+func (r *Resolver) sort(selected map[string]bool, providers map[string]string) ([]string, error) {
+	names := []string{}
+	for n := range selected {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+
+	const (
+		unvisited = iota
+		visiting
+		done
+	)
+	state := map[string]int{}
+	order := []string{}
+	var visit func(name string, path []string) error
+	visit = func(name string, path []string) error {
+		path = append(path, name)
+		switch state[name] {
+		case done:
+			return nil
+		case visiting:
+			return fmt.Errorf("Dependency cycle: %s", strings.Join(path, " -> "))
+		}
+		state[name] = visiting
+		for _, d := range r.components[name].deps {
+			if p, ok := providers[d]; ok {
+				d = p
+			}
+			if err := visit(d, path); err != nil {
+				return err
+			}
+		}
+		state[name] = done
+		order = append(order, name)
+		return nil
+	}
+	for _, n := range names {
+		if err := visit(n, nil); err != nil {
+			return nil, err
+		}
+	}
+	return order, nil
+}
@@ -0,0 +1,188 @@
+package install
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func testComponents() []Component {
+	return []Component{
+		{Name: "crds"},
+		{Name: "istio", provides: "ingress", preferred: true, deps: []string{"crds"}, Gateway: &Gateway{"istio-system", "istio-ingressgateway"}},
+		{Name: "gloo", provides: "ingress", Gateway: &Gateway{"gloo-system", "clusteringress-proxy"}},
+		{Name: "serving", deps: []string{"ingress", "crds"}},
+		{Name: "build", deps: []string{"crds"}},
+		{Name: "eventing", deps: []string{"serving", "build"}},
+		{Name: "a", deps: []string{"b"}},
+		{Name: "b", deps: []string{"c"}},
+		{Name: "c", deps: []string{"a"}},
+		{Name: "x", provides: "logging"},
+		{Name: "y", provides: "logging"},
+		{Name: "monitoring", deps: []string{"logging"}},
+	}
+}
+
+func steps(p *Plan) []string {
+	ret := []string{}
+	for _, s := range p.Steps {
+		ret = append(ret, s.Name)
+	}
+	return ret
+}
+
+func TestResolve(t *testing.T) {
+	tests := []struct {
+		name      string
+		request   []string
+		prefer    map[string]string
+		want      []string
+		providers []Resolution
+		err       string
+	}{{
+		name:    "topological order",
+		request: []string{"eventing"},
+		want:    []string{"crds", "build", "istio", "serving", "eventing"},
+	}, {
+		name:    "cycle",
+		request: []string{"a"},
+		err:     "Dependency cycle: a -> b -> c -> a",
+	}, {
+		name:    "two providers selected",
+		request: []string{"serving", "istio", "gloo"},
+		err:     `Components istio, gloo all provide "ingress"`,
+	}, {
+		name:      "catalog preferred provider",
+		request:   []string{"serving"},
+		want:      []string{"crds", "istio", "serving"},
+		providers: []Resolution{{Dependency: "ingress", Provider: "istio", Reason: ReasonPreferred}},
+	}, {
+		name:      "--prefer overrides the catalog",
+		request:   []string{"serving"},
+		prefer:    map[string]string{"ingress": "gloo"},
+		want:      []string{"crds", "gloo", "serving"},
+		providers: []Resolution{{Dependency: "ingress", Provider: "gloo", Reason: ReasonPrefer}},
+	}, {
+		name:      "selected provider",
+		request:   []string{"gloo", "serving"},
+		want:      []string{"crds", "gloo", "serving"},
+		providers: []Resolution{{Dependency: "ingress", Provider: "gloo", Reason: ReasonSelected}},
+	}, {
+		name:    "no provider preferred",
+		request: []string{"monitoring"},
+		err:     `Multiple components provide "logging" (x, y)`,
+	}, {
+		name:    "unknown component",
+		request: []string{"nope"},
+		err:     `Unknown component "nope"`,
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewResolver(testComponents(), NoPromptChooser)
+			if err := r.Prefer(tt.prefer); err != nil {
+				t.Fatalf("Prefer(%v) = %v", tt.prefer, err)
+			}
+			plan, err := r.Resolve(tt.request)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("Resolve(%v) = %v, want error containing %q", tt.request, err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Resolve(%v) = %v", tt.request, err)
+			}
+			if got := steps(plan); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Resolve(%v) steps = %v, want %v", tt.request, got, tt.want)
+			}
+			if tt.providers != nil && !reflect.DeepEqual(plan.Providers, tt.providers) {
+				t.Errorf("Resolve(%v) providers = %v, want %v", tt.request, plan.Providers, tt.providers)
+			}
+		})
+	}
+}
+
+func TestPrefer(t *testing.T) {
+	r := NewResolver(testComponents(), NoPromptChooser)
+	if err := r.Prefer(map[string]string{"ingress": "serving"}); err == nil {
+		t.Error(`Prefer(ingress=serving) succeeded, want an error`)
+	}
+	if err := r.Prefer(map[string]string{"storage": "gloo"}); err == nil {
+		t.Error(`Prefer(storage=gloo) succeeded, want an error`)
+	}
+}
+
+func TestResolveChooser(t *testing.T) {
+	offered := []string{}
+	r := NewResolver(testComponents(), func(dep string, candidates []Component) (Component, error) {
+		for _, c := range candidates {
+			offered = append(offered, c.Name)
+		}
+		return candidates[1], nil
+	})
+	plan, err := r.Resolve([]string{"monitoring"})
+	if err != nil {
+		t.Fatalf("Resolve(monitoring) = %v", err)
+	}
+	if got, want := steps(plan), []string{"y", "monitoring"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Resolve(monitoring) steps = %v, want %v", got, want)
+	}
+	if want := []string{"x", "y"}; !reflect.DeepEqual(offered, want) {
+		t.Errorf("Chooser offered %v, want %v", offered, want)
+	}
+	if want := ReasonChosen; plan.Providers[0].Reason != want {
+		t.Errorf("Resolve(monitoring) reason = %q, want %q", plan.Providers[0].Reason, want)
+	}
+}
+
+func TestExpand(t *testing.T) {
+	defer func(saved []Component) { components = saved }(components)
+	components = testComponents()
+	byName := map[string]Component{}
+	for _, c := range components {
+		byName[c.Name] = c
+	}
+	got := []string{}
+	for _, c := range byName["serving"].Expand([]Component{byName["build"]}) {
+		got = append(got, c.Name)
+	}
+	if want := []string{"crds", "build", "istio", "serving"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Expand() = %v, want %v", got, want)
+	}
+}
+
+func TestPlanGateway(t *testing.T) {
+	tests := []struct {
+		request []string
+		prefer  map[string]string
+		want    *Gateway
+	}{
+		{[]string{"eventing"}, nil, &Gateway{"istio-system", "istio-ingressgateway"}},
+		{[]string{"serving"}, map[string]string{"ingress": "gloo"}, &Gateway{"gloo-system", "clusteringress-proxy"}},
+		{[]string{"build"}, nil, nil},
+	}
+	for _, tt := range tests {
+		r := NewResolver(testComponents(), NoPromptChooser)
+		if err := r.Prefer(tt.prefer); err != nil {
+			t.Fatalf("Prefer(%v) = %v", tt.prefer, err)
+		}
+		plan, err := r.Resolve(tt.request)
+		if err != nil {
+			t.Fatalf("Resolve(%v) = %v", tt.request, err)
+		}
+		if got := plan.Gateway(); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Resolve(%v).Gateway() = %v, want %v", tt.request, got, tt.want)
+		}
+	}
+}
+
+func TestGateways(t *testing.T) {
+	want := []Gateway{
+		{"istio-system", "istio-ingressgateway"},
+		{"gloo-system", "clusteringress-proxy"},
+		{"ambassador", "ambassador"},
+	}
+	if got := Gateways(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Gateways() = %v, want %v", got, want)
+	}
+}
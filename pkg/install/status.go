@@ -0,0 +1,46 @@
+package install
+
+import (
+	"github.com/evankanderson/knuts/pkg"
+)
+
+// ComponentStatus describes the state of a Component in a cluster.
+type ComponentStatus struct {
+	Name      string `json:"name" yaml:"name"`
+	Installed bool   `json:"installed" yaml:"installed"`
+	// Version is the installed release, if it could be determined.
+	Version string `json:"version,omitempty" yaml:"version,omitempty"`
+	// Available and Deployments count the Component's Deployments which are
+	// Available, and the total.
+	Available   int  `json:"availableDeployments" yaml:"availableDeployments"`
+	Deployments int  `json:"deployments" yaml:"deployments"`
+	Healthy     bool `json:"healthy" yaml:"healthy"`
+}
+
+// Status reports whether the Component is installed in cluster, which
+// release is installed, and whether its Deployments are Available.
+func (c Component) Status(cluster *pkg.Cluster) (ComponentStatus, error) {
+	s := ComponentStatus{Name: c.Name}
+	installed, err := c.Installed(cluster)
+	if err != nil || !installed {
+		return s, err
+	}
+	s.Installed = true
+	if s.Version, err = c.InstalledVersion(cluster); err != nil {
+		return s, err
+	}
+	for _, ns := range c.Namespaces {
+		deployments, err := cluster.Deployments(ns)
+		if err != nil {
+			return s, err
+		}
+		for _, d := range deployments {
+			s.Deployments++
+			if d.Available {
+				s.Available++
+			}
+		}
+	}
+	s.Healthy = s.Available == s.Deployments
+	return s, nil
+}
@@ -0,0 +1,88 @@
+package install
+
+import (
+	"fmt"
+	"sort"
+)
+
+// RemovalOrder returns the named Components in the order they should be
+// uninstalled: each Component appears before any of its dependencies.
+func (r *Resolver) RemovalOrder(names []string) ([]Component, error) {
+	removing := map[string]bool{}
+	for _, n := range names {
+		if _, ok := r.components[n]; !ok {
+			return nil, fmt.Errorf("Unknown component %q", n)
+		}
+		removing[n] = true
+	}
+	// Virtual dependencies only order removal if their provider is also
+	// being removed.
+	resolved := map[string]string{}
+	for dep, names := range r.providers {
+		for _, n := range names {
+			if removing[n] {
+				resolved[dep] = n
+			}
+		}
+	}
+	order, err := r.sort(removing, resolved)
+	if err != nil {
+		return nil, err
+	}
+	ret := []Component{}
+	for i := len(order) - 1; i >= 0; i-- {
+		if removing[order[i]] {
+			ret = append(ret, r.components[order[i]])
+		}
+	}
+	return ret, nil
+}
+
+// Dependents returns, for each named Component, the installed Components
+// which would be left without a dependency if it were removed. Virtual
+// dependencies are still satisfied if another installed provider remains.
+func (r *Resolver) Dependents(names []string, installed map[string]bool) map[string][]string {
+	removing := map[string]bool{}
+	for _, n := range names {
+		removing[n] = true
+	}
+	remaining := func(name string) bool {
+		return installed[name] && !removing[name]
+	}
+
+	ret := map[string][]string{}
+	users := []string{}
+	for n := range installed {
+		users = append(users, n)
+	}
+	sort.Strings(users)
+	for _, user := range users {
+		if !remaining(user) {
+			continue
+		}
+		for _, d := range r.components[user].deps {
+			providers, virtual := r.providers[d]
+			if !virtual {
+				if removing[d] {
+					ret[d] = append(ret[d], user)
+				}
+				continue
+			}
+			satisfied := false
+			for _, p := range providers {
+				if remaining(p) {
+					satisfied = true
+				}
+			}
+			if satisfied {
+				continue
+			}
+			for _, p := range providers {
+				if removing[p] && installed[p] {
+					ret[p] = append(ret[p], user)
+				}
+			}
+		}
+	}
+	return ret
+}
@@ -0,0 +1,154 @@
+// Package lock records exactly which manifests knuts applied, so that the
+// same install can be repeated on another cluster.
+package lock
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/evankanderson/knuts/pkg/install"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// DefaultPath is the lockfile used if none is specified.
+const DefaultPath = "knuts.lock"
+
+const header = "# Written by knuts; replay with `knuts install --locked` and `knuts builds --locked`.\n"
+
+// Lock is the contents of a lockfile.
+type Lock struct {
+	// Release is the Knative release the Components were resolved from.
+	Release string `yaml:"release,omitempty"`
+	// ImageRegistry is the registry images were relocated to, if any.
+	ImageRegistry string `yaml:"imageRegistry,omitempty"`
+	// Components is in install order; each appears after its dependencies.
+	Components []Component `yaml:"components,omitempty"`
+	Providers  []Provider  `yaml:"providers,omitempty"`
+	Templates  []Template  `yaml:"templates,omitempty"`
+}
+
+// Component is an installed Knative component.
+type Component struct {
+	Name    string `yaml:"name"`
+	Version string `yaml:"version,omitempty"`
+	URL     string `yaml:"url"`
+	// SHA256 is the digest of the manifest which was applied, in the form
+	// produced by pkg.Digest.
+	SHA256     string   `yaml:"sha256"`
+	Namespaces []string `yaml:"namespaces,omitempty"`
+	// Config is the Component's changes to other Components' ConfigMaps.
+	Config     []install.ConfigPatch `yaml:"config,omitempty"`
+	Kubernetes install.VersionRange  `yaml:"kubernetes,omitempty"`
+	// Gateway is the ingress provider's gateway Service, if any.
+	Gateway *install.Gateway `yaml:"gateway,omitempty"`
+}
+
+// Provider records which Component satisfied a virtual dependency.
+type Provider struct {
+	Dependency string `yaml:"dependency"`
+	Provider   string `yaml:"provider"`
+}
+
+// Template is an installed BuildTemplate.
+type Template struct {
+	Name string `yaml:"name"`
+	URL  string `yaml:"url"`
+	// Commit is the git commit URL was pinned to, if it could be resolved.
+	Commit string `yaml:"commit,omitempty"`
+	SHA256 string `yaml:"sha256"`
+}
+
+// Read loads the lockfile at path.
+func Read(path string) (*Lock, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	l := &Lock{}
+	if err := yaml.UnmarshalStrict(data, l); err != nil {
+		return nil, fmt.Errorf("Unable to parse lockfile %s: %v", path, err)
+	}
+	return l, nil
+}
+
+// Update applies f to the lockfile at path, creating it if it does not
+// exist.
+func Update(path string, f func(*Lock)) error {
+	l, err := Read(path)
+	if os.IsNotExist(err) {
+		l, err = &Lock{}, nil
+	}
+	if err != nil {
+		return err
+	}
+	f(l)
+	return l.Write(path)
+}
+
+// Write saves l to path.
+func (l *Lock) Write(path string) error {
+	data, err := yaml.Marshal(l)
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(path, append([]byte(header), data...), 0644); err != nil {
+		return fmt.Errorf("Unable to write lockfile %s: %v", path, err)
+	}
+	return nil
+}
+
+// SetRelease records the release which the components are from. If it
+// changes, the components and providers recorded for the previous release
+// are removed.
+func (l *Lock) SetRelease(release string) {
+	if l.Release != release {
+		l.Components = nil
+		l.Providers = nil
+	}
+	l.Release = release
+}
+
+// SetComponent adds c, replacing any existing entry with the same name.
+func (l *Lock) SetComponent(c Component) {
+	for i := range l.Components {
+		if l.Components[i].Name == c.Name {
+			l.Components[i] = c
+			return
+		}
+	}
+	l.Components = append(l.Components, c)
+}
+
+// SetProvider adds p, replacing any existing entry for the same dependency.
+// If the provider changes, the previous provider's Component is removed.
+func (l *Lock) SetProvider(p Provider) {
+	for i := range l.Providers {
+		if l.Providers[i].Dependency != p.Dependency {
+			continue
+		}
+		if old := l.Providers[i].Provider; old != p.Provider {
+			kept := []Component{}
+			for _, c := range l.Components {
+				if c.Name != old {
+					kept = append(kept, c)
+				}
+			}
+			l.Components = kept
+		}
+		l.Providers[i] = p
+		return
+	}
+	l.Providers = append(l.Providers, p)
+}
+
+// SetTemplate adds t, replacing any existing entry with the same name.
+func (l *Lock) SetTemplate(t Template) {
+	for i := range l.Templates {
+		if l.Templates[i].Name == t.Name {
+			l.Templates[i] = t
+			return
+		}
+	}
+	l.Templates = append(l.Templates, t)
+}
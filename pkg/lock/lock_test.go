@@ -0,0 +1,65 @@
+package lock
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/evankanderson/knuts/pkg/install"
+)
+
+func TestWriteRead(t *testing.T) {
+	want := &Lock{
+		Release:       "v0.3.0",
+		ImageRegistry: "registry.local:5000",
+		Components: []Component{{
+			Name:       "istio-sidecar",
+			URL:        "https://example.dev/istio.yaml",
+			SHA256:     "sha256:0123",
+			Namespaces: []string{"istio-system"},
+			Gateway:    &install.Gateway{Namespace: "istio-system", Name: "istio-ingressgateway"},
+		}, {
+			Name:       "serving",
+			Version:    "v0.3.0",
+			URL:        "https://example.dev/serving.yaml",
+			SHA256:     "sha256:4567",
+			Namespaces: []string{"knative-serving"},
+			Config: []install.ConfigPatch{{
+				Namespace: "knative-serving",
+				ConfigMap: "config-network",
+				Data:      map[string]string{"clusteringress.class": "istio"},
+			}},
+			Kubernetes: install.VersionRange{Min: "1.11"},
+		}},
+		Providers: []Provider{{Dependency: "ingress", Provider: "istio-sidecar"}},
+		Templates: []Template{{Name: "kaniko", URL: "https://example.dev/kaniko.yaml", Commit: "abc", SHA256: "sha256:89ab"}},
+	}
+	path := filepath.Join(t.TempDir(), DefaultPath)
+	if err := want.Write(path); err != nil {
+		t.Fatalf("Write() = %v", err)
+	}
+	got, err := Read(path)
+	if err != nil {
+		t.Fatalf("Read() = %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Read() = %+v, want %+v", got, want)
+	}
+}
+
+func TestSetRelease(t *testing.T) {
+	l := &Lock{
+		Release:    "v0.2.2",
+		Components: []Component{{Name: "serving"}},
+		Providers:  []Provider{{Dependency: "ingress", Provider: "istio"}},
+		Templates:  []Template{{Name: "kaniko"}},
+	}
+	l.SetRelease("v0.2.2")
+	if len(l.Components) != 1 || len(l.Providers) != 1 {
+		t.Errorf("SetRelease(same) = %+v, want components kept", l)
+	}
+	l.SetRelease("v0.3.0")
+	if l.Release != "v0.3.0" || l.Components != nil || l.Providers != nil || len(l.Templates) != 1 {
+		t.Errorf("SetRelease(new) = %+v, want only templates kept", l)
+	}
+}
@@ -0,0 +1,128 @@
+package pkg
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/evankanderson/knuts/pkg/bundle"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// AllowUnverified permits using manifests which have no pinned digest.
+// Manifests whose content does not match a pinned digest are always
+// rejected.
+var AllowUnverified = false
+
+// Bundle, if set, provides local copies of all manifests so that they can
+// be applied without network access.
+var Bundle *bundle.Bundle
+
+// fetched caches manifest contents by URL, so that each is only read once.
+// It is shared by concurrent installs to several clusters.
+var (
+	fetched   = map[string][]byte{}
+	fetchedMu sync.Mutex
+)
+
+// Digest returns the pinned form of the SHA-256 digest of data, e.g.
+// "sha256:e3b0c4...".
+func Digest(data []byte) string {
+	sum := sha256.Sum256(data)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// Fetch returns the contents of the manifest at url, which may be an
+// http(s) URL or a local path. If Bundle is set, the manifest is read from
+// the bundle instead. The contents are checked against digest, which may be
+// a bare hex SHA-256 or prefixed with "sha256:".
+func Fetch(url string, digest string) ([]byte, error) {
+	fetchedMu.Lock()
+	data, ok := fetched[url]
+	fetchedMu.Unlock()
+	if !ok {
+		var err error
+		if data, err = read(url); err != nil {
+			return nil, err
+		}
+	}
+	if digest == "" {
+		if !AllowUnverified {
+			return nil, fmt.Errorf("%s has no pinned digest (got %s); use --allow-unverified to use it anyway", url, Digest(data))
+		}
+	} else if want := "sha256:" + strings.TrimPrefix(digest, "sha256:"); want != Digest(data) {
+		return nil, fmt.Errorf("%s does not match its pinned digest: want %s, got %s", url, want, Digest(data))
+	}
+	fetchedMu.Lock()
+	fetched[url] = data
+	fetchedMu.Unlock()
+	return data, nil
+}
+
+func read(url string) ([]byte, error) {
+	if Bundle != nil {
+		return Bundle.Read(url)
+	}
+	if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+		return ioutil.ReadFile(strings.TrimPrefix(url, "file://"))
+	}
+	resp, err := http.Get(url)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to download %s: %v", url, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Unable to download %s: %s", url, resp.Status)
+	}
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to download %s: %v", url, err)
+	}
+	return data, nil
+}
+
+// Object identifies a single kubernetes object in a manifest.
+type Object struct {
+	APIVersion string
+	Kind       string
+	Namespace  string
+	Name       string
+}
+
+// Objects lists the kubernetes objects in a multi-document yaml manifest.
+func Objects(contents []byte) ([]Object, error) {
+	ret := []Object{}
+	d := yaml.NewDecoder(bytes.NewReader(contents))
+	for {
+		o := struct {
+			APIVersion string `yaml:"apiVersion"`
+			Kind       string `yaml:"kind"`
+			Metadata   struct {
+				Namespace string `yaml:"namespace"`
+				Name      string `yaml:"name"`
+			} `yaml:"metadata"`
+		}{}
+		err := d.Decode(&o)
+		if err == io.EOF {
+			return ret, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Unable to parse manifest: %v", err)
+		}
+		if o.Kind == "" {
+			continue
+		}
+		ret = append(ret, Object{
+			APIVersion: o.APIVersion,
+			Kind:       o.Kind,
+			Namespace:  o.Metadata.Namespace,
+			Name:       o.Metadata.Name,
+		})
+	}
+}
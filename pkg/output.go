@@ -0,0 +1,65 @@
+package pkg
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Output is a flags.Value implementing interface which selects the format
+// used to print a command's results.
+type Output struct {
+	// Formats lists the allowed formats. The first is the default.
+	Formats []string
+	value   string
+}
+
+// String implements the flag.Value interface.
+func (o *Output) String() string {
+	if o.value == "" && len(o.Formats) > 0 {
+		return o.Formats[0]
+	}
+	return o.value
+}
+
+// Set implements the flag.Value interface.
+func (o *Output) Set(value string) error {
+	for _, f := range o.Formats {
+		if f == value {
+			o.value = value
+			return nil
+		}
+	}
+	return fmt.Errorf("Unknown format %q, must be one of %s", value, strings.Join(o.Formats, ", "))
+}
+
+// Type implements the pflag.Value interface.
+func (o *Output) Type() string {
+	return "format"
+}
+
+// Description returns a flag usage string listing the allowed formats.
+func (o *Output) Description() string {
+	return fmt.Sprintf("Output format, one of: %s.", strings.Join(o.Formats, ", "))
+}
+
+// PrintStructured writes v to w in the "json" or "yaml" format.
+func PrintStructured(w io.Writer, format string, v interface{}) error {
+	switch format {
+	case "json":
+		e := json.NewEncoder(w)
+		e.SetIndent("", "  ")
+		return e.Encode(v)
+	case "yaml":
+		b, err := yaml.Marshal(v)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(b)
+		return err
+	}
+	return fmt.Errorf("Unsupported output format %q", format)
+}
@@ -0,0 +1,193 @@
+package overlay
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// apply performs a single RFC 6902 operation on doc.
+func (o op) apply(doc interface{}) (interface{}, error) {
+	path, err := pointer(o.Path)
+	if err != nil {
+		return nil, err
+	}
+	switch o.Op {
+	case "add":
+		return edit(doc, path, add(clone(o.Value)))
+	case "remove":
+		return edit(doc, path, del)
+	case "replace":
+		return edit(doc, path, replace(clone(o.Value)))
+	case "move", "copy":
+		from, err := pointer(o.From)
+		if err != nil {
+			return nil, err
+		}
+		v, err := get(doc, from)
+		if err != nil {
+			return nil, err
+		}
+		if o.Op == "move" {
+			if doc, err = edit(doc, from, del); err != nil {
+				return nil, err
+			}
+		}
+		return edit(doc, path, add(clone(v)))
+	case "test":
+		v, err := get(doc, path)
+		if err != nil {
+			return nil, err
+		}
+		if !reflect.DeepEqual(v, o.Value) {
+			return nil, fmt.Errorf("test failed: %s is %v, not %v", o.Path, v, o.Value)
+		}
+		return doc, nil
+	}
+	return nil, fmt.Errorf("Unknown JSON patch operation %q", o.Op)
+}
+
+// pointer parses an RFC 6901 JSON pointer into its reference tokens.
+func pointer(p string) ([]string, error) {
+	if !strings.HasPrefix(p, "/") {
+		return nil, fmt.Errorf("Invalid JSON pointer %q", p)
+	}
+	tokens := strings.Split(p[1:], "/")
+	for i, t := range tokens {
+		tokens[i] = strings.Replace(strings.Replace(t, "~1", "/", -1), "~0", "~", -1)
+	}
+	return tokens, nil
+}
+
+// editFunc changes the member token of container, returning the new
+// container.
+type editFunc func(container interface{}, token string) (interface{}, error)
+
+// edit applies f to the parent of the value at path within node.
+func edit(node interface{}, path []string, f editFunc) (interface{}, error) {
+	if len(path) == 1 {
+		return f(node, path[0])
+	}
+	child, err := get(node, path[:1])
+	if err != nil {
+		return nil, err
+	}
+	if child, err = edit(child, path[1:], f); err != nil {
+		return nil, err
+	}
+	return replace(child)(node, path[0])
+}
+
+func get(node interface{}, path []string) (interface{}, error) {
+	for _, t := range path {
+		switch n := node.(type) {
+		case yaml.MapSlice:
+			found := false
+			for _, item := range n {
+				if str(item.Key) == t {
+					node, found = item.Value, true
+				}
+			}
+			if !found {
+				return nil, fmt.Errorf("%q not found", t)
+			}
+		case []interface{}:
+			i, err := listIndex(n, t, false)
+			if err != nil {
+				return nil, err
+			}
+			node = n[i]
+		default:
+			return nil, fmt.Errorf("%q not found", t)
+		}
+	}
+	return node, nil
+}
+
+func add(value interface{}) editFunc {
+	return func(container interface{}, token string) (interface{}, error) {
+		switch c := container.(type) {
+		case yaml.MapSlice:
+			return put(c, token, value), nil
+		case []interface{}:
+			if token == "-" {
+				return append(c, value), nil
+			}
+			i, err := listIndex(c, token, true)
+			if err != nil {
+				return nil, err
+			}
+			ret := append(append([]interface{}{}, c[:i]...), value)
+			return append(ret, c[i:]...), nil
+		}
+		return nil, fmt.Errorf("Unable to add %q to a non-container", token)
+	}
+}
+
+func replace(value interface{}) editFunc {
+	return func(container interface{}, token string) (interface{}, error) {
+		if _, err := get(container, []string{token}); err != nil {
+			return nil, err
+		}
+		if l, ok := container.([]interface{}); ok {
+			i, _ := listIndex(l, token, false)
+			l[i] = value
+			return l, nil
+		}
+		return put(container.(yaml.MapSlice), token, value), nil
+	}
+}
+
+func del(container interface{}, token string) (interface{}, error) {
+	if _, err := get(container, []string{token}); err != nil {
+		return nil, err
+	}
+	if l, ok := container.([]interface{}); ok {
+		i, _ := listIndex(l, token, false)
+		return without(l, i), nil
+	}
+	return remove(container.(yaml.MapSlice), token), nil
+}
+
+// without returns a copy of l without item i, rather than shifting the items
+// of l, whose backing array may be shared with a value read by get.
+func without(l []interface{}, i int) []interface{} {
+	return append(append([]interface{}{}, l[:i]...), l[i+1:]...)
+}
+
+// listIndex parses token as an index into l. If insert is true, the index
+// may be one past the end.
+func listIndex(l []interface{}, token string, insert bool) (int, error) {
+	i, err := strconv.Atoi(token)
+	max := len(l) - 1
+	if insert {
+		max = len(l)
+	}
+	if err != nil || i < 0 || i > max {
+		return 0, fmt.Errorf("Invalid list index %q", token)
+	}
+	return i, nil
+}
+
+// clone returns a deep copy of a value decoded from yaml, so that patch
+// values are not shared between the objects they are applied to.
+func clone(v interface{}) interface{} {
+	switch n := v.(type) {
+	case yaml.MapSlice:
+		ret := make(yaml.MapSlice, len(n))
+		for i, item := range n {
+			ret[i] = yaml.MapItem{Key: item.Key, Value: clone(item.Value)}
+		}
+		return ret
+	case []interface{}:
+		ret := make([]interface{}, len(n))
+		for i, item := range n {
+			ret[i] = clone(item)
+		}
+		return ret
+	}
+	return v
+}
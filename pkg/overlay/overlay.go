@@ -0,0 +1,341 @@
+// Package overlay patches the objects in kubernetes manifests before they
+// are applied, e.g. to change resource limits or replica counts.
+//
+// An overlay is a directory of .yaml, .yml or .json files, each holding one
+// or more patches. A patch is either a strategic merge patch, which is a
+// partial object identified by its kind, metadata.namespace and
+// metadata.name:
+//
+//	kind: Deployment
+//	metadata:
+//	  namespace: knative-serving
+//	  name: activator
+//	spec:
+//	  replicas: 3
+//
+// or an RFC 6902 JSON patch, with its target given explicitly:
+//
+//	target: {kind: Deployment, namespace: knative-serving, name: controller}
+//	patch:
+//	- {op: replace, path: /spec/replicas, value: 2}
+package overlay
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Target identifies the object a patch applies to.
+type Target struct {
+	Kind      string
+	Namespace string
+	Name      string
+}
+
+func (t Target) String() string {
+	if t.Namespace == "" {
+		return t.Kind + " " + t.Name
+	}
+	return t.Kind + " " + t.Namespace + "/" + t.Name
+}
+
+// Overlay is a set of patches loaded from a directory.
+type Overlay struct {
+	patches []*patch
+}
+
+type patch struct {
+	file   string
+	target Target
+	// Exactly one of merge and ops is set.
+	merge yaml.MapSlice
+	ops   []op
+	used  bool
+}
+
+type op struct {
+	Op    string
+	Path  string
+	From  string
+	Value interface{}
+}
+
+// Load reads the patches in dir.
+func Load(dir string) (*Overlay, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read overlay: %v", err)
+	}
+	o := &Overlay{}
+	for _, f := range files {
+		switch filepath.Ext(f.Name()) {
+		case ".yaml", ".yml", ".json":
+		default:
+			continue
+		}
+		path := filepath.Join(dir, f.Name())
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		docs, err := decode(data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		for _, d := range docs {
+			p, err := parsePatch(d)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", path, err)
+			}
+			p.file = path
+			o.patches = append(o.patches, p)
+		}
+	}
+	return o, nil
+}
+
+func parsePatch(d yaml.MapSlice) (*patch, error) {
+	if t, ok := lookup(d, "target").(yaml.MapSlice); ok {
+		p := &patch{target: Target{Kind: str(lookup(t, "kind")), Namespace: str(lookup(t, "namespace")), Name: str(lookup(t, "name"))}}
+		ops, ok := lookup(d, "patch").([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("JSON patch for %s must have a list of operations in `patch`", p.target)
+		}
+		for _, o := range ops {
+			m, ok := o.(yaml.MapSlice)
+			if !ok {
+				return nil, fmt.Errorf("Invalid JSON patch operation %v for %s", o, p.target)
+			}
+			p.ops = append(p.ops, op{Op: str(lookup(m, "op")), Path: str(lookup(m, "path")), From: str(lookup(m, "from")), Value: lookup(m, "value")})
+		}
+		if p.target.Kind == "" || p.target.Name == "" {
+			return nil, fmt.Errorf("JSON patch target must have a kind and name")
+		}
+		return p, nil
+	}
+	p := &patch{target: targetOf(d), merge: d}
+	if p.target.Kind == "" || p.target.Name == "" {
+		return nil, fmt.Errorf("patch must be an object with a kind and metadata.name, or a JSON patch with a target")
+	}
+	return p, nil
+}
+
+// Add adds a strategic merge patch for target, to be applied after those
+// loaded from files. Unlike those, it is not reported by Unused.
+func (o *Overlay) Add(target Target, merge yaml.MapSlice) {
+	o.patches = append(o.patches, &patch{target: target, merge: merge})
+}
+
+// Used reports whether any patch for target has been applied.
+func (o *Overlay) Used(target Target) bool {
+	for _, p := range o.patches {
+		if p.target == target && p.used {
+			return true
+		}
+	}
+	return false
+}
+
+// Apply returns contents with every matching patch applied. It also
+// returns just the patched objects, which is empty if nothing matched.
+func (o *Overlay) Apply(contents []byte) ([]byte, []byte, error) {
+	docs, err := decode(contents)
+	if err != nil {
+		return nil, nil, err
+	}
+	out := bytes.Buffer{}
+	patched := bytes.Buffer{}
+	for i, d := range docs {
+		t := targetOf(d)
+		changed := false
+		for _, p := range o.patches {
+			if p.target != t {
+				continue
+			}
+			var node interface{} = d
+			if p.merge != nil {
+				node = merge(d, clone(p.merge), "")
+			}
+			for _, op := range p.ops {
+				if node, err = op.apply(node); err != nil {
+					return nil, nil, fmt.Errorf("%s: patching %s: %v", p.file, t, err)
+				}
+			}
+			result, ok := node.(yaml.MapSlice)
+			if !ok {
+				return nil, nil, fmt.Errorf("%s: patching %s did not produce an object", p.file, t)
+			}
+			d = result
+			p.used = true
+			changed = true
+		}
+		docs[i] = d
+		b, err := yaml.Marshal(docs[i])
+		if err != nil {
+			return nil, nil, err
+		}
+		out.WriteString("---\n")
+		out.Write(b)
+		if changed {
+			patched.WriteString("---\n")
+			patched.Write(b)
+		}
+	}
+	if patched.Len() == 0 {
+		return contents, nil, nil
+	}
+	return out.Bytes(), patched.Bytes(), nil
+}
+
+// Unused describes the patches which have not matched any object.
+func (o *Overlay) Unused() []string {
+	ret := []string{}
+	for _, p := range o.patches {
+		if !p.used && p.file != "" {
+			ret = append(ret, fmt.Sprintf("%s (%s)", p.target, p.file))
+		}
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+// mergeKeys gives the field used to match list items for lists which are
+// not keyed by "name".
+var mergeKeys = map[string]string{
+	"ports":        "containerPort",
+	"volumeMounts": "mountPath",
+}
+
+// merge applies the strategic merge patch src to dst, where key is the
+// field holding them. Maps are merged recursively and null values remove
+// fields. Lists of objects are merged by their merge key (usually "name"),
+// and items with `$patch: delete` are removed; other lists are replaced.
+func merge(dst interface{}, src interface{}, key string) interface{} {
+	switch s := src.(type) {
+	case yaml.MapSlice:
+		d, ok := dst.(yaml.MapSlice)
+		if !ok {
+			return s
+		}
+		for _, item := range s {
+			k := str(item.Key)
+			if item.Value == nil {
+				d = remove(d, k)
+				continue
+			}
+			d = put(d, k, merge(lookup(d, k), item.Value, k))
+		}
+		return d
+	case []interface{}:
+		d, ok := dst.([]interface{})
+		mk := mergeKeys[key]
+		if mk == "" {
+			mk = "name"
+		}
+		if !ok || !keyed(s, mk) || !keyed(d, mk) {
+			return s
+		}
+		for _, item := range s {
+			m := item.(yaml.MapSlice)
+			i := index(d, mk, lookup(m, mk))
+			switch {
+			case str(lookup(m, "$patch")) == "delete":
+				if i >= 0 {
+					d = without(d, i)
+				}
+			case i >= 0:
+				d[i] = merge(d[i], m, "")
+			default:
+				d = append(d, m)
+			}
+		}
+		return d
+	}
+	return src
+}
+
+// keyed reports whether every item in l is an object with the field key.
+func keyed(l []interface{}, key string) bool {
+	for _, item := range l {
+		m, ok := item.(yaml.MapSlice)
+		if !ok || lookup(m, key) == nil {
+			return false
+		}
+	}
+	return true
+}
+
+func index(l []interface{}, key string, value interface{}) int {
+	for i, item := range l {
+		if str(lookup(item.(yaml.MapSlice), key)) == str(value) {
+			return i
+		}
+	}
+	return -1
+}
+
+func decode(contents []byte) ([]yaml.MapSlice, error) {
+	ret := []yaml.MapSlice{}
+	d := yaml.NewDecoder(bytes.NewReader(contents))
+	for {
+		doc := yaml.MapSlice{}
+		err := d.Decode(&doc)
+		if err == io.EOF {
+			return ret, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Unable to parse manifest: %v", err)
+		}
+		if len(doc) > 0 {
+			ret = append(ret, doc)
+		}
+	}
+}
+
+func targetOf(d yaml.MapSlice) Target {
+	meta, _ := lookup(d, "metadata").(yaml.MapSlice)
+	return Target{Kind: str(lookup(d, "kind")), Namespace: str(lookup(meta, "namespace")), Name: str(lookup(meta, "name"))}
+}
+
+func lookup(m yaml.MapSlice, key string) interface{} {
+	for _, item := range m {
+		if str(item.Key) == key {
+			return item.Value
+		}
+	}
+	return nil
+}
+
+func put(m yaml.MapSlice, key string, value interface{}) yaml.MapSlice {
+	for i, item := range m {
+		if str(item.Key) == key {
+			m[i].Value = value
+			return m
+		}
+	}
+	return append(m, yaml.MapItem{Key: key, Value: value})
+}
+
+func remove(m yaml.MapSlice, key string) yaml.MapSlice {
+	ret := yaml.MapSlice{}
+	for _, item := range m {
+		if str(item.Key) != key {
+			ret = append(ret, item)
+		}
+	}
+	return ret
+}
+
+func str(v interface{}) string {
+	if v == nil {
+		return ""
+	}
+	return strings.TrimSpace(fmt.Sprint(v))
+}
@@ -0,0 +1,398 @@
+package overlay
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+const deployment = `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: controller
+  namespace: knative-serving
+spec:
+  replicas: 1
+  template:
+    spec:
+      containers:
+      - name: controller
+        image: gcr.io/controller
+        args: [-v, "1"]
+        ports:
+        - containerPort: 8080
+          name: http
+        - containerPort: 9090
+          name: metrics
+      - name: sidecar
+        image: gcr.io/sidecar
+`
+
+// load writes patches to a new overlay directory and loads it.
+func load(t *testing.T, patches string) *Overlay {
+	t.Helper()
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "patch.yaml"), []byte(patches), 0644); err != nil {
+		t.Fatal(err)
+	}
+	o, err := Load(dir)
+	if err != nil {
+		t.Fatalf("Load() = %v", err)
+	}
+	return o
+}
+
+// canonical re-encodes a single yaml document, so that documents can be
+// compared regardless of formatting.
+func canonical(t *testing.T, doc string) string {
+	t.Helper()
+	docs, err := decode([]byte(doc))
+	if err != nil || len(docs) != 1 {
+		t.Fatalf("decode(%q) = %v, %v", doc, docs, err)
+	}
+	b, err := yaml.Marshal(docs[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestApply(t *testing.T) {
+	tests := []struct {
+		name  string
+		patch string
+		// want is deployment after applying patch.
+		want string
+		err  string
+	}{{
+		name: "merge map",
+		patch: `
+kind: Deployment
+metadata: {namespace: knative-serving, name: controller, labels: {team: a}}
+spec: {replicas: 3}
+`,
+		want: `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: controller
+  namespace: knative-serving
+  labels: {team: a}
+spec:
+  replicas: 3
+  template:
+    spec:
+      containers:
+      - name: controller
+        image: gcr.io/controller
+        args: [-v, "1"]
+        ports:
+        - {containerPort: 8080, name: http}
+        - {containerPort: 9090, name: metrics}
+      - {name: sidecar, image: gcr.io/sidecar}
+`,
+	}, {
+		name: "merge list by name, replace scalar list, null removes",
+		patch: `
+kind: Deployment
+metadata: {namespace: knative-serving, name: controller}
+spec:
+  replicas: null
+  template:
+    spec:
+      containers:
+      - name: sidecar
+        image: gcr.io/sidecar:v2
+      - name: extra
+        image: gcr.io/extra
+      - name: controller
+        args: [-v, "2"]
+`,
+		want: `
+apiVersion: apps/v1
+kind: Deployment
+metadata: {name: controller, namespace: knative-serving}
+spec:
+  template:
+    spec:
+      containers:
+      - name: controller
+        image: gcr.io/controller
+        args: [-v, "2"]
+        ports:
+        - {containerPort: 8080, name: http}
+        - {containerPort: 9090, name: metrics}
+      - {name: sidecar, image: "gcr.io/sidecar:v2"}
+      - {name: extra, image: gcr.io/extra}
+`,
+	}, {
+		name: "merge list by merge key, $patch: delete",
+		patch: `
+kind: Deployment
+metadata: {namespace: knative-serving, name: controller}
+spec:
+  template:
+    spec:
+      containers:
+      - name: controller
+        ports:
+        - {containerPort: 9090, $patch: delete}
+        - {containerPort: 8080, name: http2}
+      - {name: sidecar, $patch: delete}
+`,
+		want: `
+apiVersion: apps/v1
+kind: Deployment
+metadata: {name: controller, namespace: knative-serving}
+spec:
+  replicas: 1
+  template:
+    spec:
+      containers:
+      - name: controller
+        image: gcr.io/controller
+        args: [-v, "1"]
+        ports:
+        - {containerPort: 8080, name: http2}
+`,
+	}, {
+		name: "json add, replace and remove",
+		patch: `
+target: {kind: Deployment, namespace: knative-serving, name: controller}
+patch:
+- {op: add, path: /metadata/labels, value: {team: a}}
+- {op: add, path: /spec/template/spec/containers/0/args/1, value: "--x"}
+- {op: add, path: /spec/template/spec/containers/0/args/-, value: "--y"}
+- {op: replace, path: /spec/replicas, value: 2}
+- {op: replace, path: /spec/template/spec/containers/1/image, value: gcr.io/other}
+- {op: remove, path: /spec/template/spec/containers/0/ports/0}
+- {op: remove, path: /spec/template/spec/containers/0/image}
+`,
+		want: `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: controller
+  namespace: knative-serving
+  labels: {team: a}
+spec:
+  replicas: 2
+  template:
+    spec:
+      containers:
+      - name: controller
+        args: [-v, "--x", "1", "--y"]
+        ports:
+        - {containerPort: 9090, name: metrics}
+      - {name: sidecar, image: gcr.io/other}
+`,
+	}, {
+		name: "json move and copy",
+		patch: `
+target: {kind: Deployment, namespace: knative-serving, name: controller}
+patch:
+- {op: move, from: /spec/template/spec/containers/0, path: /spec/template/spec/containers/1}
+- {op: copy, from: /spec/template/spec/containers/0/image, path: /metadata/image}
+- {op: move, from: /spec/replicas, path: /metadata/replicas}
+- {op: test, path: /metadata/replicas, value: 1}
+`,
+		want: `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: controller
+  namespace: knative-serving
+  image: gcr.io/sidecar
+  replicas: 1
+spec:
+  template:
+    spec:
+      containers:
+      - {name: sidecar, image: gcr.io/sidecar}
+      - name: controller
+        image: gcr.io/controller
+        args: [-v, "1"]
+        ports:
+        - {containerPort: 8080, name: http}
+        - {containerPort: 9090, name: metrics}
+`,
+	}, {
+		name: "json escaped pointer",
+		patch: `
+target: {kind: Deployment, namespace: knative-serving, name: controller}
+patch:
+- {op: add, path: /metadata/annotations, value: {}}
+- {op: add, path: /metadata/annotations/example.dev~1a~0b, value: "on"}
+`,
+		want: `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: controller
+  namespace: knative-serving
+  annotations: {example.dev/a~b: "on"}
+spec:
+  replicas: 1
+  template:
+    spec:
+      containers:
+      - name: controller
+        image: gcr.io/controller
+        args: [-v, "1"]
+        ports:
+        - {containerPort: 8080, name: http}
+        - {containerPort: 9090, name: metrics}
+      - {name: sidecar, image: gcr.io/sidecar}
+`,
+	}, {
+		name: "json test fails",
+		patch: `
+target: {kind: Deployment, namespace: knative-serving, name: controller}
+patch:
+- {op: test, path: /spec/replicas, value: 2}
+`,
+		err: "test failed",
+	}, {
+		name: "json missing path",
+		patch: `
+target: {kind: Deployment, namespace: knative-serving, name: controller}
+patch:
+- {op: replace, path: /spec/paused, value: true}
+`,
+		err: `"paused" not found`,
+	}, {
+		name: "json bad index",
+		patch: `
+target: {kind: Deployment, namespace: knative-serving, name: controller}
+patch:
+- {op: remove, path: /spec/template/spec/containers/2}
+`,
+		err: `Invalid list index "2"`,
+	}, {
+		name: "json unknown op",
+		patch: `
+target: {kind: Deployment, namespace: knative-serving, name: controller}
+patch:
+- {op: frob, path: /spec}
+`,
+		err: `Unknown JSON patch operation "frob"`,
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o := load(t, tt.patch)
+			out, patched, err := o.Apply([]byte(deployment))
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("Apply() = %v, want error containing %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Apply() = %v", err)
+			}
+			want := canonical(t, tt.want)
+			if got := canonical(t, string(out)); got != want {
+				t.Errorf("Apply() =\n%s\nwant:\n%s", got, want)
+			}
+			if got := canonical(t, string(patched)); got != want {
+				t.Errorf("Apply() patched =\n%s\nwant:\n%s", got, want)
+			}
+			if unused := o.Unused(); len(unused) != 0 {
+				t.Errorf("Unused() = %v, want none", unused)
+			}
+		})
+	}
+}
+
+// TestApplyRepeated checks that applying an overlay does not change the
+// overlay itself, e.g. through list items shared with a patched manifest,
+// so that it can be applied to several manifests or clusters.
+func TestApplyRepeated(t *testing.T) {
+	o := load(t, `
+kind: Deployment
+metadata: {namespace: knative-serving, name: controller}
+spec:
+  template:
+    spec:
+      containers:
+      - {name: extra, image: gcr.io/extra, args: [a, b, c]}
+---
+target: {kind: Deployment, namespace: knative-serving, name: controller}
+patch:
+- {op: remove, path: /spec/template/spec/containers/2/args/0}
+- {op: add, path: /spec/template/spec/containers/2/args/0, value: z}
+- {op: move, from: /spec/template/spec/containers/2/args/2, path: /spec/template/spec/containers/2/args/0}
+`)
+	first, _, err := o.Apply([]byte(deployment))
+	if err != nil {
+		t.Fatalf("Apply() = %v", err)
+	}
+	if !strings.Contains(string(first), "- c\n        - z\n        - b\n") {
+		t.Errorf("Apply() =\n%s\nwant args [c, z, b]", first)
+	}
+	second, _, err := o.Apply([]byte(deployment))
+	if err != nil {
+		t.Fatalf("Apply() again = %v", err)
+	}
+	if string(first) != string(second) {
+		t.Errorf("Apply() again =\n%s\nwant:\n%s", second, first)
+	}
+}
+
+func TestUnused(t *testing.T) {
+	o := load(t, `
+kind: Deployment
+metadata: {namespace: knative-serving, name: controller}
+spec: {replicas: 2}
+---
+kind: Deployment
+metadata: {namespace: knative-serving, name: activator}
+spec: {replicas: 2}
+`)
+	o.Add(Target{Kind: "ConfigMap", Namespace: "knative-serving", Name: "config-domain"}, yaml.MapSlice{})
+	if _, _, err := o.Apply([]byte(deployment)); err != nil {
+		t.Fatalf("Apply() = %v", err)
+	}
+	unused := o.Unused()
+	if len(unused) != 1 || !strings.HasPrefix(unused[0], "Deployment knative-serving/activator (") {
+		t.Errorf("Unused() = %v, want only the activator patch", unused)
+	}
+	if !o.Used(Target{Kind: "Deployment", Namespace: "knative-serving", Name: "controller"}) {
+		t.Errorf("Used(controller) = false, want true")
+	}
+}
+
+func TestApplyUnmatched(t *testing.T) {
+	o := load(t, `
+kind: Service
+metadata: {namespace: knative-serving, name: controller}
+spec: {type: NodePort}
+`)
+	out, patched, err := o.Apply([]byte(deployment))
+	if err != nil {
+		t.Fatalf("Apply() = %v", err)
+	}
+	if string(out) != deployment || patched != nil {
+		t.Errorf("Apply() changed an unmatched manifest:\n%s", out)
+	}
+}
+
+func TestLoadInvalid(t *testing.T) {
+	for _, patch := range []string{
+		"spec: {replicas: 2}",
+		"target: {kind: Deployment, name: x}\npatch: {op: add}",
+		"target: {kind: Deployment}\npatch: []",
+	} {
+		dir := t.TempDir()
+		if err := ioutil.WriteFile(filepath.Join(dir, "patch.yaml"), []byte(patch), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := Load(dir); err == nil {
+			t.Errorf("Load(%q) succeeded, want an error", patch)
+		}
+	}
+}
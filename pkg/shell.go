@@ -1,9 +1,22 @@
 package pkg
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/AlecAivazis/survey"
+	"github.com/evankanderson/knuts/pkg/apply"
+	"github.com/evankanderson/knuts/pkg/images"
+	"github.com/evankanderson/knuts/pkg/overlay"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 )
 
 // Installed returns a nicely-formatted error message if the given command-line tool is not installed.
@@ -15,27 +28,396 @@ func Installed(command string) error {
 	return err
 }
 
-// Kubectl applies a yaml file with kubectl. Kubectl is not amenable to being called as a library.
-func Kubectl(file string, output *os.File) error {
-	cmd := exec.Command("kubectl", "apply", "--filename", file)
-	cmd.Stdout = output
-	cmd.Stderr = output
-	if DryRun {
-		fmt.Printf("Dry run: `kubectl apply --filename %q`\n", file)
+// Cluster is a kubernetes cluster to act on, along with the options for
+// acting on it. Commands which act on a single cluster use Default, which
+// is set by the global flags; acting on several clusters at once needs a
+// Cluster for each, so that the runs share no state.
+type Cluster struct {
+	// Kubeconfig and Context, if set, select the kubeconfig file and the
+	// context in it to use, rather than kubectl's defaults.
+	Kubeconfig string
+	Context    string
+
+	// Namespace, if set, is the namespace for namespaced objects which do
+	// not specify one, rather than the namespace of the kubeconfig context.
+	Namespace string
+
+	// DryRun prints operations rather than executing them.
+	DryRun bool
+
+	// UseKubectl runs the kubectl binary, rather than using the built-in
+	// client.
+	UseKubectl bool
+
+	// Overlay, if set, is applied to manifests by Kubectl before any image
+	// relocation.
+	Overlay *overlay.Overlay
+
+	// ImageRegistry, if set, is the registry which Kubectl relocates all
+	// images in applied manifests to; see images.Relocate.
+	ImageRegistry string
+
+	// Out receives progress messages and the output of kubectl. If nil,
+	// os.Stdout is used.
+	Out io.Writer
+
+	// Confirmed skips the banner and prompt before the first change to the
+	// cluster, because Confirm has already shown them.
+	Confirmed bool
+
+	client          *apply.Client
+	confirmationErr error
+}
+
+// Default is the Cluster selected by the global flags.
+var Default = &Cluster{DryRun: true}
+
+// Output returns the writer for c's progress messages.
+func (c *Cluster) Output() io.Writer {
+	if c.Out == nil {
+		return os.Stdout
+	}
+	return c.Out
+}
+
+// KubectlCommand returns a kubectl command with args, using c's kubeconfig,
+// context and namespace.
+func (c *Cluster) KubectlCommand(args ...string) *exec.Cmd {
+	global := []string{}
+	if c.Kubeconfig != "" {
+		global = append(global, "--kubeconfig", c.Kubeconfig)
+	}
+	if c.Context != "" {
+		global = append(global, "--context", c.Context)
+	}
+	if c.Namespace != "" {
+		global = append(global, "--namespace", c.Namespace)
+	}
+	return exec.Command("kubectl", append(global, args...)...)
+}
+
+// Server returns the name of c's kubeconfig context, and the URL of its API
+// server.
+func (c *Cluster) Server() (string, string, error) {
+	return apply.Server(c.Kubeconfig, c.Context)
+}
+
+// confirm calls Confirm for c before its first change, and returns the
+// result then.
+func (c *Cluster) confirm() error {
+	if !c.Confirmed {
+		Confirm(c.Output(), c)
+	}
+	return c.confirmationErr
+}
+
+// Confirm prints the clusters which are about to be changed to out and,
+// when running interactively, asks once whether to continue. The clusters
+// do not print or ask again before their first change.
+func Confirm(out io.Writer, clusters ...*Cluster) error {
+	err := confirm(out, clusters)
+	for _, c := range clusters {
+		c.Confirmed = true
+		c.confirmationErr = err
+	}
+	return err
+}
+
+func confirm(out io.Writer, clusters []*Cluster) error {
+	for _, c := range clusters {
+		name, server, err := c.Server()
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "*** Changing cluster %s (context %s) ***\n", server, name)
+	}
+	if !Interactive() {
 		return nil
 	}
-	return cmd.Run()
+	ok := true
+	if err := survey.AskOne(&survey.Confirm{Message: "Continue?", Default: true}, &ok, nil); err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("Cancelled before making any changes")
+	}
+	return nil
 }
 
-// KubectlInline applies suplied yaml contents with kubectl. Kubectl is not amenable to being called as a library.
-func KubectlInline(contents []byte, output *os.File) error {
-	cmd := exec.Command("kubectl", "apply", "--filename", "-")
-	cmd.Stdout = output
-	cmd.Stderr = output
-	if DryRun {
-		fmt.Printf("Dry run: `kubectl apply < INPUT`\n")
+// Access returns a nicely-formatted error message if knuts has no way to
+// reach the cluster: kubectl is not installed when UseKubectl is set, or
+// there is no kubeconfig for the built-in client. In a dry run, the
+// built-in client is only created by the operations which read the
+// cluster, so that printing changes does not need a kubeconfig.
+func (c *Cluster) Access() error {
+	if c.UseKubectl {
+		return Installed("kubectl")
+	}
+	if c.DryRun {
 		return nil
 	}
+	_, err := c.Client()
+	return err
+}
+
+// Client returns the built-in client for c, creating it on first use.
+func (c *Cluster) Client() (*apply.Client, error) {
+	if c.client == nil {
+		client, err := apply.NewClient(c.Kubeconfig, c.Context)
+		if err != nil {
+			return nil, err
+		}
+		if c.Namespace != "" {
+			client = client.WithNamespace(c.Namespace)
+		}
+		c.client = client
+	}
+	return c.client, nil
+}
+
+// InNamespace returns a copy of c which acts in namespace, sharing its
+// connection. Confirm c first to ask only once for all its namespaces.
+func (c *Cluster) InNamespace(namespace string) *Cluster {
+	copy := *c
+	copy.Namespace = namespace
+	if c.client != nil {
+		copy.client = c.client.WithNamespace(namespace)
+	}
+	return &copy
+}
+
+// Namespaces returns the names of the namespaces matching a label selector,
+// or of all namespaces if selector is empty. This is a read-only operation,
+// so it is run even when DryRun is set.
+func (c *Cluster) Namespaces(selector string) ([]string, error) {
+	if !c.UseKubectl {
+		client, err := c.Client()
+		if err != nil {
+			return nil, err
+		}
+		names, err := client.Namespaces(selector)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to list namespaces: %v", err)
+		}
+		return names, nil
+	}
+	args := []string{"get", "namespaces", "--output", "name"}
+	if selector != "" {
+		args = append(args, "--selector", selector)
+	}
+	cmd := c.KubectlCommand(args...)
+	var out, stderr bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("Unable to list namespaces: %v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	names := []string{}
+	for _, n := range strings.Fields(out.String()) {
+		names = append(names, strings.TrimPrefix(n, "namespace/"))
+	}
+	return names, nil
+}
+
+// printResults prints the outcome for each object, and returns an error
+// describing any which failed.
+func (c *Cluster) printResults(results []apply.Result) error {
+	for _, r := range results {
+		if r.Err == nil {
+			fmt.Fprintln(c.Output(), r)
+		}
+	}
+	return apply.Errors(results)
+}
+
+// Kubectl applies the manifest at url, after checking it against digest
+// (see Fetch) and applying any Overlay and ImageRegistry. The name is
+// historical: kubectl itself is only used if UseKubectl is set.
+func (c *Cluster) Kubectl(url string, digest string) error {
+	contents, err := Fetch(url, digest)
+	if err != nil {
+		return err
+	}
+	var patched []byte
+	if c.Overlay != nil {
+		if contents, patched, err = c.Overlay.Apply(contents); err != nil {
+			return fmt.Errorf("%s: %v", url, err)
+		}
+	}
+	if c.ImageRegistry != "" {
+		if contents, _, err = images.Relocate(contents, c.ImageRegistry); err != nil {
+			return fmt.Errorf("%s: %v", url, err)
+		}
+	}
+	if c.DryRun {
+		if c.ImageRegistry != "" {
+			fmt.Fprintf(c.Output(), "Dry run: `kubectl apply --filename %q` with images from %s\n", url, c.ImageRegistry)
+		} else {
+			fmt.Fprintf(c.Output(), "Dry run: `kubectl apply --filename %q`\n", url)
+		}
+		if len(patched) > 0 {
+			fmt.Fprintf(c.Output(), "Patched objects:\n%s", patched)
+		}
+		return nil
+	}
+	if err := c.confirm(); err != nil {
+		return err
+	}
+	if err := c.apply(contents); err != nil {
+		return fmt.Errorf("%s: %v", url, err)
+	}
+	return nil
+}
+
+// apply applies a manifest, printing the outcome for each object.
+func (c *Cluster) apply(contents []byte) error {
+	if c.UseKubectl {
+		var stderr bytes.Buffer
+		if err := c.kubectl(contents, c.Output(), &stderr, "apply", "--filename", "-"); err != nil {
+			return fmt.Errorf("kubectl apply failed: %v: %s", err, strings.TrimSpace(stderr.String()))
+		}
+		return nil
+	}
+	client, err := c.Client()
+	if err != nil {
+		return err
+	}
+	results, err := client.Apply(contents)
+	if err != nil {
+		return err
+	}
+	return c.printResults(results)
+}
+
+// KubectlDelete deletes the objects in the manifest at url,
+// ignoring any which do not exist.
+func (c *Cluster) KubectlDelete(url string, digest string) error {
+	contents, err := Fetch(url, digest)
+	if err != nil {
+		return err
+	}
+	if c.DryRun {
+		fmt.Fprintf(c.Output(), "Dry run: `kubectl delete --ignore-not-found --filename %q`\n", url)
+		return nil
+	}
+	if err := c.confirm(); err != nil {
+		return err
+	}
+	if c.UseKubectl {
+		var stderr bytes.Buffer
+		if err := c.kubectl(contents, c.Output(), &stderr, "delete", "--ignore-not-found", "--filename", "-"); err != nil {
+			return fmt.Errorf("kubectl delete failed: %v: %s", err, strings.TrimSpace(stderr.String()))
+		}
+		return nil
+	}
+	client, err := c.Client()
+	if err != nil {
+		return err
+	}
+	results, err := client.Delete(contents)
+	if err != nil {
+		return fmt.Errorf("%s: %v", url, err)
+	}
+	return c.printResults(results)
+}
+
+// Exists reports whether any of the objects in the manifest at url exist
+// in the cluster. This is a read-only operation, so it is run even when
+// DryRun is set.
+func (c *Cluster) Exists(url string, digest string) (bool, error) {
+	contents, err := Fetch(url, digest)
+	if err != nil {
+		return false, err
+	}
+	if !c.UseKubectl {
+		client, err := c.Client()
+		if err != nil {
+			return false, err
+		}
+		ok, err := client.Exists(contents)
+		if err != nil {
+			return false, fmt.Errorf("Unable to check %s: %v", url, err)
+		}
+		return ok, nil
+	}
+	// `kubectl get --filename` fails if any object's kind is unknown to the
+	// cluster (e.g. its CRD is not installed), so only ask about objects of
+	// known kinds, as the built-in client does.
+	objects, err := Objects(contents)
+	if err != nil {
+		return false, fmt.Errorf("%s: %v", url, err)
+	}
+	kinds, err := c.kinds()
+	if err != nil {
+		return false, err
+	}
+	var known bytes.Buffer
+	for _, o := range objects {
+		group := ""
+		if i := strings.LastIndex(o.APIVersion, "/"); i >= 0 {
+			group = o.APIVersion[:i]
+		}
+		if !kinds[group+"/"+o.Kind] {
+			continue
+		}
+		fmt.Fprintf(&known, "---\napiVersion: %s\nkind: %s\nmetadata:\n  name: %q\n", o.APIVersion, o.Kind, o.Name)
+		if o.Namespace != "" {
+			fmt.Fprintf(&known, "  namespace: %q\n", o.Namespace)
+		}
+	}
+	if known.Len() == 0 {
+		return false, nil
+	}
+	var out, stderr bytes.Buffer
+	if err := c.kubectl(known.Bytes(), &out, &stderr, "get", "--ignore-not-found", "--filename", "-", "--output", "name"); err != nil {
+		return false, fmt.Errorf("Unable to check %s: %v: %s", url, err, strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimSpace(out.String()) != "", nil
+}
+
+// kinds returns the kinds the cluster serves, as "group/Kind" (e.g.
+// "apps/Deployment", or "/ConfigMap" for the core group).
+func (c *Cluster) kinds() (map[string]bool, error) {
+	cmd := c.KubectlCommand("api-resources", "--no-headers")
+	var out, stderr bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("Unable to list API resources: %v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	ret := map[string]bool{}
+	for _, line := range strings.Split(out.String(), "\n") {
+		// NAME [SHORTNAMES] APIVERSION NAMESPACED KIND
+		f := strings.Fields(line)
+		if len(f) < 4 {
+			continue
+		}
+		group := ""
+		if i := strings.LastIndex(f[len(f)-3], "/"); i >= 0 {
+			group = f[len(f)-3][:i]
+		}
+		ret[group+"/"+f[len(f)-1]] = true
+	}
+	return ret, nil
+}
+
+// KubectlInline applies suplied yaml contents, like Kubectl.
+func (c *Cluster) KubectlInline(contents []byte) error {
+	if c.DryRun {
+		fmt.Fprintf(c.Output(), "Dry run: `kubectl apply < INPUT`\n")
+		return nil
+	}
+	if err := c.confirm(); err != nil {
+		return err
+	}
+	return c.apply(contents)
+}
+
+// kubectl runs kubectl with args, supplying contents on stdin.
+func (c *Cluster) kubectl(contents []byte, stdout io.Writer, stderr io.Writer, args ...string) error {
+	cmd := c.KubectlCommand(args...)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
 	in, err := cmd.StdinPipe()
 	if err != nil {
 		return fmt.Errorf("Input to kubectl failed: %v", err)
@@ -47,3 +429,221 @@ func KubectlInline(contents []byte, output *os.File) error {
 
 	return cmd.Run()
 }
+
+// WaitForCRDs waits until all CustomResourceDefinitions in the manifest at
+// url are Established, so that resources of those types can be created.
+func (c *Cluster) WaitForCRDs(url string, digest string, timeout time.Duration) error {
+	contents, err := Fetch(url, digest)
+	if err != nil {
+		return err
+	}
+	objects, err := Objects(contents)
+	if err != nil {
+		return fmt.Errorf("%s: %v", url, err)
+	}
+	crds := []string{}
+	for _, o := range objects {
+		if o.Kind == "CustomResourceDefinition" {
+			crds = append(crds, o.Name)
+		}
+	}
+	if len(crds) == 0 {
+		return nil
+	}
+	if c.DryRun {
+		fmt.Fprintf(c.Output(), "Dry run: `kubectl wait --for condition=Established` on %d CRDs from %q\n", len(crds), url)
+		return nil
+	}
+	if !c.UseKubectl {
+		client, err := c.Client()
+		if err != nil {
+			return err
+		}
+		return client.WaitEstablished(crds, timeout)
+	}
+	args := []string{"wait", "--for", "condition=Established", "--timeout", timeout.String()}
+	for _, n := range crds {
+		args = append(args, "customresourcedefinition/"+n)
+	}
+	cmd := c.KubectlCommand(args...)
+	cmd.Stdout = c.Output()
+	cmd.Stderr = c.Output()
+	return cmd.Run()
+}
+
+// WaitForDeployments waits until all Deployments in namespace are Available.
+func (c *Cluster) WaitForDeployments(namespace string, timeout time.Duration) error {
+	if c.DryRun {
+		fmt.Fprintf(c.Output(), "Dry run: `kubectl wait deployment --all --for condition=Available --namespace %s`\n", namespace)
+		return nil
+	}
+	if !c.UseKubectl {
+		client, err := c.Client()
+		if err != nil {
+			return err
+		}
+		return client.WaitAvailable(namespace, timeout)
+	}
+	cmd := c.KubectlCommand("wait", "deployment", "--all", "--for", "condition=Available", "--namespace", namespace, "--timeout", timeout.String())
+	cmd.Stdout = c.Output()
+	cmd.Stderr = c.Output()
+	return cmd.Run()
+}
+
+// Deployment summarizes the state of a kubernetes Deployment.
+type Deployment struct {
+	Name      string
+	Labels    map[string]string
+	Available bool
+}
+
+// Deployments lists the Deployments in namespace. This is a read-only
+// operation, so it is run even when DryRun is set.
+func (c *Cluster) Deployments(namespace string) ([]Deployment, error) {
+	list := struct {
+		Items []struct {
+			Metadata struct {
+				Name   string            `json:"name"`
+				Labels map[string]string `json:"labels"`
+			} `json:"metadata"`
+			Status struct {
+				Conditions []struct {
+					Type   string `json:"type"`
+					Status string `json:"status"`
+				} `json:"conditions"`
+			} `json:"status"`
+		} `json:"items"`
+	}{}
+	if err := c.GetJSON(&list, "deployments.apps", namespace, ""); err != nil {
+		return nil, err
+	}
+	ret := []Deployment{}
+	for _, i := range list.Items {
+		d := Deployment{Name: i.Metadata.Name, Labels: i.Metadata.Labels}
+		for _, c := range i.Status.Conditions {
+			if c.Type == "Available" && c.Status == "True" {
+				d.Available = true
+			}
+		}
+		ret = append(ret, d)
+	}
+	return ret, nil
+}
+
+// ReleaseLabels returns the distinct values of `*.knative.dev/release`
+// labels on Deployments in namespace.
+func (c *Cluster) ReleaseLabels(namespace string) ([]string, error) {
+	deployments, err := c.Deployments(namespace)
+	if err != nil {
+		return nil, err
+	}
+	found := map[string]bool{}
+	for _, d := range deployments {
+		for k, v := range d.Labels {
+			if strings.HasSuffix(k, "knative.dev/release") {
+				found[v] = true
+			}
+		}
+	}
+	ret := []string{}
+	for v := range found {
+		ret = append(ret, v)
+	}
+	sort.Strings(ret)
+	return ret, nil
+}
+
+// ObjectExists reports whether the named object of the given kind exists
+// in c's namespace. This is a read-only operation, so it is run
+// even when DryRun is set.
+func (c *Cluster) ObjectExists(kind string, name string) (bool, error) {
+	if !c.UseKubectl {
+		client, err := c.Client()
+		if err != nil {
+			return false, err
+		}
+		_, err = client.Get(kind, "", name)
+		if apierrors.IsNotFound(err) {
+			return false, nil
+		}
+		if err != nil {
+			return false, fmt.Errorf("Unable to check %s %s: %v", kind, name, err)
+		}
+		return true, nil
+	}
+	cmd := c.KubectlCommand("get", kind, name, "--ignore-not-found", "--output", "name")
+	var out, stderr bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return false, fmt.Errorf("Unable to check %s %s: %v: %s", kind, name, err, strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimSpace(out.String()) != "", nil
+}
+
+// GetJSON gets the named object of the given resource (e.g. "configmap" or
+// "gateways.networking.istio.io") and decodes its JSON into v. If name is
+// empty, it gets a list of the objects in namespace, or in all namespaces
+// if namespace is also empty. This is a read-only operation, so it is run
+// even when DryRun is set.
+func (c *Cluster) GetJSON(v interface{}, resource string, namespace string, name string) error {
+	what := strings.TrimSpace(resource + " " + name)
+	var out []byte
+	if c.UseKubectl {
+		args := []string{"get", resource}
+		if name != "" {
+			args = append(args, name)
+		}
+		if namespace != "" {
+			args = append(args, "--namespace", namespace)
+		} else if name == "" {
+			args = append(args, "--all-namespaces")
+		}
+		cmd := c.KubectlCommand(append(args, "--output", "json")...)
+		var stdout, stderr bytes.Buffer
+		cmd.Stdout = &stdout
+		cmd.Stderr = &stderr
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("Unable to get %s: %v: %s", what, err, strings.TrimSpace(stderr.String()))
+		}
+		out = stdout.Bytes()
+	} else {
+		client, err := c.Client()
+		if err != nil {
+			return err
+		}
+		if out, err = client.Get(resource, namespace, name); err != nil {
+			return fmt.Errorf("Unable to get %s: %v", what, err)
+		}
+	}
+	if err := json.Unmarshal(out, v); err != nil {
+		return fmt.Errorf("Unable to parse %s: %v", what, err)
+	}
+	return nil
+}
+
+// Patch applies a JSON merge patch to the named object.
+func (c *Cluster) Patch(kind string, namespace string, name string, patch []byte) error {
+	if c.DryRun {
+		fmt.Fprintf(c.Output(), "Dry run: `kubectl patch %s %s --namespace %s --type merge --patch '%s'`\n", kind, name, namespace, patch)
+		return nil
+	}
+	if err := c.confirm(); err != nil {
+		return err
+	}
+	if !c.UseKubectl {
+		client, err := c.Client()
+		if err != nil {
+			return err
+		}
+		if err := client.Patch(kind, namespace, name, patch); err != nil {
+			return fmt.Errorf("Unable to patch %s %s: %v", kind, name, err)
+		}
+		fmt.Fprintf(c.Output(), "%s/%s patched\n", kind, name)
+		return nil
+	}
+	cmd := c.KubectlCommand("patch", kind, name, "--namespace", namespace, "--type", "merge", "--patch", string(patch))
+	cmd.Stdout = c.Output()
+	cmd.Stderr = c.Output()
+	return cmd.Run()
+}